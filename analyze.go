@@ -0,0 +1,72 @@
+package pngmetawebstrip
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// ImageHeader holds the fields of the IHDR chunk
+type ImageHeader struct {
+	Width      int
+	Height     int
+	BitDepth   int  // Bits per sample or palette index
+	ColorType  int  // 0 grayscale, 2 truecolor, 3 indexed, 4 grayscale+alpha, 6 truecolor+alpha
+	Interlaced bool // Adam7 interlacing
+}
+
+// parseImageHeader decodes IHDR data. Malformed headers leave the zero value.
+func parseImageHeader(data []byte) ImageHeader {
+	if len(data) != 13 {
+		return ImageHeader{}
+	}
+
+	return ImageHeader{
+		Width:      int(binary.BigEndian.Uint32(data[0:4])),
+		Height:     int(binary.BigEndian.Uint32(data[4:8])),
+		BitDepth:   int(data[8]),
+		ColorType:  int(data[9]),
+		Interlaced: data[12] == 1,
+	}
+}
+
+// Analyze validates PNG data and reports what Strip would remove without
+// producing any output. Only the chunk policy of DefaultOptions is applied:
+// kept chunks are neither inflated nor rewritten, so the savings of
+// ReplaceSRGBProfile, MinimizeProfile and ReconcileColor are not reported.
+func Analyze(data []byte) (*Result, error) {
+	return stripTo(io.Discard, data, analysisOptions())
+}
+
+// AnalyzeWithOptions is like Analyze but reports exactly what StripWithOptions
+// would do with opts, running every rewrite those options enable and taking
+// as long
+func AnalyzeWithOptions(data []byte, opts Options) (*Result, error) {
+	return stripTo(io.Discard, data, opts)
+}
+
+// AnalyzeReader is like Analyze but reads the PNG data from r one chunk at a
+// time
+func AnalyzeReader(r io.Reader) (*Result, error) {
+	sr := &Reader{src: r}
+	sr.s = newStripper(io.Discard, analysisOptions())
+
+	for {
+		if err := sr.next(); err != nil {
+			if errors.Is(err, io.EOF) {
+				return sr.s.result, nil
+			}
+			return nil, err
+		}
+	}
+}
+
+// analysisOptions returns DefaultOptions without the options that rewrite
+// kept chunks, which leaves each chunk to be counted as it is read
+func analysisOptions() Options {
+	opts := DefaultOptions()
+	opts.ReplaceSRGBProfile = false
+	opts.MinimizeProfile = false
+	opts.ReconcileColor = false
+	return opts
+}
@@ -0,0 +1,141 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	data := buildMetadataPNG(t)
+
+	_, expected, err := Strip(data)
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+
+	result, err := Analyze(data)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Analyze result %+v differs from Strip result %+v", result, expected)
+	}
+
+	readerResult, err := AnalyzeReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("AnalyzeReader failed: %v", err)
+	}
+	if !reflect.DeepEqual(readerResult, expected) {
+		t.Errorf("AnalyzeReader result %+v differs from Strip result %+v", readerResult, expected)
+	}
+
+	header := ImageHeader{Width: 4, Height: 4, BitDepth: 8, ColorType: 0}
+	if result.Header != header {
+		t.Errorf("Expected header %+v, got %+v", header, result.Header)
+	}
+}
+
+func TestAnalyzeHeader(t *testing.T) {
+	palette := color.Palette{color.Black, color.White}
+	img := image.NewPaletted(image.Rect(0, 0, 33, 17), palette)
+	encoded := encodedChunks(t, img)
+	data := buildPNG(
+		makeChunk("IHDR", encoded["IHDR"]),
+		makeChunk("PLTE", encoded["PLTE"]),
+		makeChunk("IDAT", encoded["IDAT"]),
+		makeChunk("IEND", nil),
+	)
+
+	result, err := Analyze(data)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+
+	header := ImageHeader{Width: 33, Height: 17, BitDepth: 1, ColorType: 3}
+	if result.Header != header {
+		t.Errorf("Expected header %+v, got %+v", header, result.Header)
+	}
+	if result.Total != 0 {
+		t.Errorf("Expected nothing to strip, got %d bytes", result.Total)
+	}
+}
+
+func TestAnalyzeOptions(t *testing.T) {
+	data := buildICCPNG(t, makeChunk("iCCP", iccpData(t, "sRGB", srgbTestProfile(t, 0))), makeChunk("tIME", make([]byte, 7)))
+
+	_, expected, err := Strip(data)
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+	if expected.Optimized.ColorProfile <= 0 {
+		t.Fatal("Expected Strip to replace the sRGB profile")
+	}
+
+	// Analyze counts the policy decisions only and leaves iCCP as it is
+	readerResult, err := AnalyzeReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("AnalyzeReader failed: %v", err)
+	}
+	for _, result := range []*Result{mustAnalyze(t, data), readerResult} {
+		if result.Optimized.ColorProfile != 0 || !result.Chunks[1].Kept {
+			t.Errorf("Expected iCCP counted as kept, got %+v", result.Chunks[1])
+		}
+		if result.Removed.TimeChunk != expected.Removed.TimeChunk || result.Total != expected.Removed.TimeChunk {
+			t.Errorf("Expected only tIME removed, got %d time chunk bytes and %d in total", result.Removed.TimeChunk, result.Total)
+		}
+	}
+
+	result, err := AnalyzeWithOptions(data, DefaultOptions())
+	if err != nil {
+		t.Fatalf("AnalyzeWithOptions failed: %v", err)
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("AnalyzeWithOptions result %+v differs from Strip result %+v", result, expected)
+	}
+}
+
+// mustAnalyze returns the result of Analyze, failing the test on errors
+func mustAnalyze(t *testing.T, data []byte) *Result {
+	t.Helper()
+
+	result, err := Analyze(data)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	return result
+}
+
+func TestAnalyzeErrors(t *testing.T) {
+	data := corruptCRC(t, buildMetadataPNG(t), "tEXt")
+
+	if _, err := Analyze(data); !errors.Is(err, ErrBadCRC) {
+		t.Errorf("Analyze: expected ErrBadCRC, got %v", err)
+	}
+	if _, err := AnalyzeReader(bytes.NewReader(data)); !errors.Is(err, ErrBadCRC) {
+		t.Errorf("AnalyzeReader: expected ErrBadCRC, got %v", err)
+	}
+	if _, err := AnalyzeReader(bytes.NewReader(nil)); !errors.Is(err, ErrNotPNG) {
+		t.Errorf("AnalyzeReader: expected ErrNotPNG, got %v", err)
+	}
+}
+
+func BenchmarkAnalyze(b *testing.B) {
+	path := filepath.Join("testdata", "with_mixed_chunks.png")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		b.Skip("Test file not found")
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Analyze(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
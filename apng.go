@@ -0,0 +1,41 @@
+package pngmetawebstrip
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// Animation chunks defined by the APNG specification
+var animationChunks = map[string]bool{
+	"acTL": true, // Animation control
+	"fcTL": true, // Frame control
+	"fdAT": true, // Frame data
+}
+
+// isSequencedChunk reports whether the chunk carries an APNG sequence number
+func isSequencedChunk(chunkType string) bool {
+	return chunkType == "fcTL" || chunkType == "fdAT"
+}
+
+// renumberChunk returns the chunk with its APNG sequence number set to seq.
+// The input slice is returned as is when the number is already correct,
+// otherwise a copy is made and its CRC recalculated.
+func renumberChunk(chunk []byte, seq uint32) ([]byte, error) {
+	if len(chunk) < 16 {
+		return nil, fmt.Errorf("%w: too short for sequence number", ErrInvalidChunk)
+	}
+
+	if binary.BigEndian.Uint32(chunk[8:12]) == seq {
+		return chunk, nil
+	}
+
+	renumbered := make([]byte, len(chunk))
+	copy(renumbered, chunk)
+	binary.BigEndian.PutUint32(renumbered[8:12], seq)
+
+	end := len(renumbered) - 4
+	binary.BigEndian.PutUint32(renumbered[end:], crc32.ChecksumIEEE(renumbered[4:end]))
+
+	return renumbered, nil
+}
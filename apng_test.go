@@ -0,0 +1,128 @@
+package pngmetawebstrip
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// buildAPNG creates a three frame animation whose sequence numbers start at
+// firstSeq, with text chunks between the frames
+func buildAPNG(t *testing.T, firstSeq uint32) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 16), uint8(y * 16), 0, 255})
+		}
+	}
+	encoded := encodedChunks(t, img)
+
+	actl := make([]byte, 8)
+	binary.BigEndian.PutUint32(actl[0:4], 3)
+
+	fctl := func(seq uint32) []byte {
+		data := make([]byte, 26)
+		binary.BigEndian.PutUint32(data[0:4], seq)
+		binary.BigEndian.PutUint32(data[4:8], 16)
+		binary.BigEndian.PutUint32(data[8:12], 16)
+		return makeChunk("fcTL", data)
+	}
+	fdat := func(seq uint32) []byte {
+		data := binary.BigEndian.AppendUint32(nil, seq)
+		return makeChunk("fdAT", append(data, encoded["IDAT"]...))
+	}
+
+	seq := firstSeq
+	return buildPNG(
+		makeChunk("IHDR", encoded["IHDR"]),
+		makeChunk("acTL", actl),
+		makeChunk("tEXt", []byte("Software\x00test")),
+		fctl(seq),
+		makeChunk("IDAT", encoded["IDAT"]),
+		makeChunk("tEXt", []byte("Comment\x00between frames")),
+		fctl(seq+1),
+		fdat(seq+2),
+		makeChunk("tIME", []byte{0x07, 0xE8, 1, 1, 0, 0, 0}),
+		fctl(seq+3),
+		fdat(seq+4),
+		makeChunk("IEND", nil),
+	)
+}
+
+func TestStripAnimated(t *testing.T) {
+	data := buildAPNG(t, 0)
+
+	cleaned, result, err := Strip(data)
+	if err != nil {
+		t.Fatalf("Failed to process APNG: %v", err)
+	}
+
+	if result.Frames != 3 {
+		t.Errorf("Expected 3 frames, got %d", result.Frames)
+	}
+	if result.Removed.TextChunks == 0 || result.Removed.TimeChunk == 0 {
+		t.Error("Expected text and time chunks to be removed")
+	}
+
+	want := []string{"IHDR", "acTL", "fcTL", "IDAT", "fcTL", "fdAT", "fcTL", "fdAT", "IEND"}
+	got := chunkTypes(cleaned)
+	if len(got) != len(want) {
+		t.Fatalf("Expected chunks %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Expected chunks %v, got %v", want, got)
+		}
+	}
+
+	if err := verifyImageIntegrity(data, cleaned); err != nil {
+		t.Errorf("Image integrity check failed: %v", err)
+	}
+}
+
+func TestStripAnimatedRenumbersSequence(t *testing.T) {
+	// Sequence numbers must start at zero; a file starting at 7 is repaired
+	data := buildAPNG(t, 7)
+
+	cleaned, _, err := Strip(data)
+	if err != nil {
+		t.Fatalf("Failed to process APNG: %v", err)
+	}
+
+	expected := uint32(0)
+	offset := 8
+	for offset+8 <= len(cleaned) {
+		length := int(binary.BigEndian.Uint32(cleaned[offset : offset+4]))
+		chunkType := string(cleaned[offset+4 : offset+8])
+		if isSequencedChunk(chunkType) {
+			seq := binary.BigEndian.Uint32(cleaned[offset+8 : offset+12])
+			if seq != expected {
+				t.Errorf("Chunk %s at offset %d has sequence %d, expected %d", chunkType, offset, seq, expected)
+			}
+			expected++
+		}
+		offset += 12 + length
+	}
+
+	// The rewritten chunks must carry valid CRCs
+	if _, _, err := Strip(cleaned); err != nil {
+		t.Errorf("Renumbered output failed validation: %v", err)
+	}
+}
+
+func TestStripAnimatedTruncatedFrameControl(t *testing.T) {
+	encoded := encodedChunks(t, image.NewGray(image.Rect(0, 0, 1, 1)))
+	data := buildPNG(
+		makeChunk("IHDR", encoded["IHDR"]),
+		makeChunk("fcTL", []byte{0, 0}),
+		makeChunk("IDAT", encoded["IDAT"]),
+		makeChunk("IEND", nil),
+	)
+
+	if _, _, err := Strip(data); err == nil {
+		t.Error("Expected error for fcTL without sequence number")
+	}
+}
@@ -0,0 +1,208 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// heldChunk is an output chunk held back until the end of the header, or of
+// the image when its data is rewritten
+type heldChunk struct {
+	info int    // Index of the input chunk's entry in Result.Chunks, -1 if inserted
+	data []byte // Chunk as it will be written
+}
+
+func (c heldChunk) chunkType() string {
+	return string(c.data[4:8])
+}
+
+// supersededBy lists, for each color chunk, the chunks that take precedence
+// over it. Decoders use the highest ranked one present and ignore the rest:
+// cICP, then iCCP, then sRGB, then gAMA and cHRM.
+var supersededBy = map[string][]string{
+	"iCCP": {"cICP"},
+	"sRGB": {"cICP", "iCCP"},
+	"gAMA": {"cICP", "iCCP", "sRGB"},
+	"cHRM": {"cICP", "iCCP", "sRGB"},
+}
+
+// holdsHeader reports whether opts needs the header chunks held until the
+// first PLTE or IDAT
+func holdsHeader(opts Options) bool {
+	return opts.ReconcileColor || opts.GammaToSRGB || opts.DropGamma || len(opts.Insert) > 0 || holdsChunks(opts)
+}
+
+// hold keeps a copy of an output chunk until flush is called
+func (s *stripper) hold(chunk []byte) {
+	s.held = append(s.held, heldChunk{
+		info: len(s.result.Chunks) - 1,
+		data: bytes.Clone(chunk),
+	})
+}
+
+// flush adds the inserted chunks, reconciles the color chunks held since
+// IHDR, rewrites the image data if requested and writes the remaining chunks
+// in their original order. Color chunks are only allowed before PLTE and
+// IDAT, so all of them have been seen by then. next is the offset of the
+// input chunk that ended the held sequence, or -1 at the end of the input.
+func (s *stripper) flush(next int) error {
+	held := s.held
+	s.held, s.holding = nil, false
+
+	if s.policy.opts.RepairOrder {
+		held = s.repairOrder(held)
+	}
+	if len(s.policy.opts.Insert) > 0 {
+		held = s.insert(held, next)
+	}
+
+	present := map[string]bool{}
+	for _, c := range held {
+		present[c.chunkType()] = true
+	}
+
+	if s.policy.opts.ReconcileColor {
+		held = s.dropSuperseded(held, present)
+	}
+	if (present["gAMA"] || present["cHRM"]) && !present["cICP"] && !present["iCCP"] && !present["sRGB"] {
+		held = s.normalizeGamma(held)
+	}
+	if holdsImage(s.policy.opts) {
+		held = s.rewriteImage(held)
+	}
+
+	for _, c := range held {
+		if err := s.write(c.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropSuperseded removes the color chunks that decoders ignore because a
+// higher ranked one is present
+func (s *stripper) dropSuperseded(held []heldChunk, present map[string]bool) []heldChunk {
+	kept := held[:0]
+	for _, c := range held {
+		// Inserted chunks are never dropped
+		if c.info >= 0 && superseded(c.chunkType(), present) {
+			s.discardHeld(c, ReasonSuperseded)
+			continue
+		}
+		kept = append(kept, c)
+	}
+	return kept
+}
+
+// superseded reports whether a chunk of the given type is ignored by
+// decoders because a higher ranked color chunk is present
+func superseded(chunkType string, present map[string]bool) bool {
+	for _, other := range supersededBy[chunkType] {
+		if present[other] {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeGamma handles images whose color space is only described by gAMA
+// and cHRM. Browsers apply these inconsistently, so values equivalent to
+// sRGB are replaced by an sRGB chunk and others are dropped on request.
+func (s *stripper) normalizeGamma(held []heldChunk) []heldChunk {
+	var gama, chrm []byte
+	for _, c := range held {
+		switch c.chunkType() {
+		case "gAMA":
+			gama = c.data[8 : len(c.data)-4]
+		case "cHRM":
+			chrm = c.data[8 : len(c.data)-4]
+		}
+	}
+	equivalent := isSRGBGamma(gama) && (chrm == nil || isSRGBChromaticities(chrm))
+
+	opts := s.policy.opts
+	if !(opts.GammaToSRGB && equivalent) && !opts.DropGamma {
+		return held
+	}
+
+	// The sRGB chunk takes the place of the first gAMA or cHRM chunk
+	var srgb []byte
+	if opts.GammaToSRGB && equivalent {
+		srgb = buildChunk("sRGB", []byte{0}) // Perceptual
+	}
+
+	kept := held[:0]
+	for _, c := range held {
+		chunkType := c.chunkType()
+		if chunkType != "gAMA" && chunkType != "cHRM" || c.info < 0 {
+			kept = append(kept, c)
+			continue
+		}
+
+		info := &s.result.Chunks[c.info]
+		if srgb == nil {
+			if !equivalent {
+				s.warn(chunkType, info.Offset, "color space is not sRGB, chunk dropped")
+			}
+			s.discardHeld(c, ReasonGamma)
+			continue
+		}
+
+		info.Kept = false
+		info.Reason = ReasonSRGB
+		saved := len(c.data)
+		if !s.hasSRGB {
+			kept = append(kept, heldChunk{info: c.info, data: srgb})
+			s.hasSRGB = true
+			saved -= len(srgb)
+		}
+		s.result.Optimized.GammaChunks += saved
+		s.result.Total += saved
+	}
+	return kept
+}
+
+// discardHeld removes a held chunk, recording reason in its log entry
+func (s *stripper) discardHeld(c heldChunk, reason string) {
+	info := &s.result.Chunks[c.info]
+	info.Kept = false
+	info.Reason = reason
+	trackRemovedChunk(s.result, c.chunkType(), len(c.data))
+}
+
+// gAMA and cHRM values written alongside sRGB, scaled by 100000
+const srgbGamma = 45455
+
+var srgbChromaticities = [8]uint32{
+	31270, 32900, // White point
+	64000, 33000, // Red
+	30000, 60000, // Green
+	15000, 6000, // Blue
+}
+
+// isSRGBGamma reports whether gAMA data holds the sRGB gamma of 1/2.2
+func isSRGBGamma(data []byte) bool {
+	if len(data) != 4 {
+		return false
+	}
+	return within(binary.BigEndian.Uint32(data), srgbGamma, 100)
+}
+
+// isSRGBChromaticities reports whether cHRM data holds the sRGB white point
+// and primaries
+func isSRGBChromaticities(data []byte) bool {
+	if len(data) != 32 {
+		return false
+	}
+	for i, v := range srgbChromaticities {
+		if !within(binary.BigEndian.Uint32(data[4*i:]), v, 100) {
+			return false
+		}
+	}
+	return true
+}
+
+// within reports whether a and b differ by at most tolerance
+func within(a, b, tolerance uint32) bool {
+	return max(a, b)-min(a, b) <= tolerance
+}
@@ -0,0 +1,201 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"testing"
+)
+
+func TestReconcileColor(t *testing.T) {
+	gama := makeChunk("gAMA", []byte{0, 0, 0xB1, 0x8F})
+	chrm := makeChunk("cHRM", make([]byte, 32))
+	srgb := makeChunk("sRGB", []byte{0})
+	phys := makeChunk("pHYs", []byte{0, 0, 0x2E, 0x23, 0, 0, 0x2E, 0x23, 1})
+	iccp := makeChunk("iCCP", iccpData(t, "Display P3", loadTestProfile(t)))
+	cicp := makeChunk("cICP", []byte{9, 16, 0, 1})
+	srgbProfile := makeChunk("iCCP", iccpData(t, "sRGB", srgbTestProfile(t, 0)))
+
+	tests := []struct {
+		name     string
+		chunks   [][]byte
+		expected []string
+	}{
+		{"sRGB", [][]byte{gama, chrm, phys, srgb}, []string{"IHDR", "pHYs", "sRGB", "IDAT", "IEND"}},
+		{"iCCP", [][]byte{gama, srgb, iccp, phys}, []string{"IHDR", "iCCP", "pHYs", "IDAT", "IEND"}},
+		{"cICP", [][]byte{iccp, cicp, gama}, []string{"IHDR", "cICP", "IDAT", "IEND"}},
+		{"sRGB profile", [][]byte{gama, srgbProfile}, []string{"IHDR", "sRGB", "IDAT", "IEND"}},
+		{"gAMA only", [][]byte{gama, chrm, phys}, []string{"IHDR", "gAMA", "cHRM", "pHYs", "IDAT", "IEND"}},
+	}
+
+	// Keep profiles verbatim so that all savings come from superseded chunks
+	opts := DefaultOptions()
+	opts.MinimizeProfile = false
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := buildICCPNG(t, tt.chunks...)
+			cleaned, result, err := StripWithOptions(data, opts)
+			if err != nil {
+				t.Fatalf("Failed to process PNG: %v", err)
+			}
+
+			if types := chunkTypes(cleaned); !slices.Equal(types, tt.expected) {
+				t.Errorf("Expected chunks %v, got %v", tt.expected, types)
+			}
+			if result.Total != len(data)-len(cleaned) {
+				t.Errorf("Total is %d, expected %d", result.Total, len(data)-len(cleaned))
+			}
+
+			removed := 0
+			for _, info := range result.Chunks {
+				if info.Reason == ReasonSuperseded {
+					removed += info.Length + 12
+					if info.Kept {
+						t.Errorf("Superseded %s marked as kept", info.Type)
+					}
+				}
+			}
+			if result.Removed.ColorChunks != removed {
+				t.Errorf("ColorChunks is %d, superseded entries add up to %d", result.Removed.ColorChunks, removed)
+			}
+		})
+	}
+}
+
+func TestReconcileColorDisabled(t *testing.T) {
+	data := buildICCPNG(t,
+		makeChunk("gAMA", []byte{0, 0, 0xB1, 0x8F}),
+		makeChunk("sRGB", []byte{0}),
+	)
+
+	cleaned, _, err := StripWithOptions(data, Options{KeepColor: true})
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+	if !bytes.Equal(cleaned, data) {
+		t.Errorf("Expected all color chunks to be kept, got %v", chunkTypes(cleaned))
+	}
+}
+
+func TestReconcileColorStreaming(t *testing.T) {
+	data := buildICCPNG(t,
+		makeChunk("gAMA", []byte{0, 0, 0xB1, 0x8F}),
+		makeChunk("tEXt", []byte("Comment\x00hello")),
+		makeChunk("sRGB", []byte{0}),
+	)
+	expected, _, err := Strip(data)
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+
+	cleaned, err := io.ReadAll(NewReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatalf("Failed to read stripped PNG: %v", err)
+	}
+	if !bytes.Equal(cleaned, expected) {
+		t.Error("Reader output differs from Strip output")
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Error("Writer output differs from Strip output")
+	}
+
+	// Held chunks are written when the input ends early
+	header := data[:len(data)-len(makeChunk("IEND", nil))]
+	header = header[:bytes.Index(header, []byte("IDAT"))-4]
+	cleaned, err = io.ReadAll(NewReader(bytes.NewReader(header)))
+	if err != nil {
+		t.Fatalf("Failed to read stripped PNG: %v", err)
+	}
+	if types := chunkTypes(cleaned); !slices.Equal(types, []string{"IHDR", "sRGB"}) {
+		t.Errorf("Expected held chunks to be flushed, got %v", types)
+	}
+}
+
+func TestGammaToSRGB(t *testing.T) {
+	gama := makeChunk("gAMA", []byte{0, 0, 0xB1, 0x8F})
+	chrm := makeChunk("cHRM", []byte{
+		0, 0, 0x7A, 0x26, 0, 0, 0x80, 0x84, // White point
+		0, 0, 0xFA, 0x00, 0, 0, 0x80, 0xE8, // Red
+		0, 0, 0x75, 0x30, 0, 0, 0xEA, 0x60, // Green
+		0, 0, 0x3A, 0x98, 0, 0, 0x17, 0x70, // Blue
+	})
+	wideGamut := bytes.Clone(chrm)
+	wideGamut[18] = 0x80 // Green x = 0.328
+	phys := makeChunk("pHYs", []byte{0, 0, 0x2E, 0x23, 0, 0, 0x2E, 0x23, 1})
+
+	opts := DefaultOptions()
+	opts.GammaToSRGB = true
+
+	tests := []struct {
+		name     string
+		chunks   [][]byte
+		dropped  bool
+		expected []string
+		warnings int
+	}{
+		{"gAMA", [][]byte{gama, phys}, false, []string{"IHDR", "sRGB", "pHYs", "IDAT", "IEND"}, 0},
+		{"gAMA and cHRM", [][]byte{phys, chrm, gama}, false, []string{"IHDR", "pHYs", "sRGB", "IDAT", "IEND"}, 0},
+		{"Gamma 1.8", [][]byte{makeChunk("gAMA", []byte{0, 0, 0xD9, 0x03})}, false, []string{"IHDR", "gAMA", "IDAT", "IEND"}, 0},
+		{"Wide gamut", [][]byte{gama, makeChunk("cHRM", wideGamut)}, false, []string{"IHDR", "gAMA", "cHRM", "IDAT", "IEND"}, 0},
+		{"Dropped gamma 1.8", [][]byte{makeChunk("gAMA", []byte{0, 0, 0xD9, 0x03}), phys}, true, []string{"IHDR", "pHYs", "IDAT", "IEND"}, 1},
+		{"Dropped wide gamut", [][]byte{gama, makeChunk("cHRM", wideGamut)}, true, []string{"IHDR", "IDAT", "IEND"}, 2},
+		{"Dropped sRGB gamma", [][]byte{gama}, true, []string{"IHDR", "sRGB", "IDAT", "IEND"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := opts
+			opts.DropGamma = tt.dropped
+
+			data := buildICCPNG(t, tt.chunks...)
+			cleaned, result, err := StripWithOptions(data, opts)
+			if err != nil {
+				t.Fatalf("Failed to process PNG: %v", err)
+			}
+
+			if types := chunkTypes(cleaned); !slices.Equal(types, tt.expected) {
+				t.Errorf("Expected chunks %v, got %v", tt.expected, types)
+			}
+			if hasChunk(cleaned, "sRGB") && !bytes.Contains(cleaned, makeChunk("sRGB", []byte{0})) {
+				t.Error("Expected an sRGB chunk with perceptual intent")
+			}
+			if len(result.Warnings) != tt.warnings {
+				t.Errorf("Expected %d warnings, got %v", tt.warnings, result.Warnings)
+			}
+			if result.Total != len(data)-len(cleaned) {
+				t.Errorf("Total is %d, expected %d", result.Total, len(data)-len(cleaned))
+			}
+			if result.Removed.ColorChunks+result.Optimized.GammaChunks != result.Total {
+				t.Errorf("Unexpected savings: removed=%d optimized=%d total=%d",
+					result.Removed.ColorChunks, result.Optimized.GammaChunks, result.Total)
+			}
+		})
+	}
+}
+
+func TestGammaToSRGBWithOtherColorChunks(t *testing.T) {
+	// gAMA next to sRGB or iCCP is left to ReconcileColor
+	opts := Options{KeepColor: true, GammaToSRGB: true, DropGamma: true}
+	data := buildICCPNG(t,
+		makeChunk("gAMA", []byte{0, 0, 0xD9, 0x03}),
+		makeChunk("sRGB", []byte{1}),
+	)
+
+	cleaned, result, err := StripWithOptions(data, opts)
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+	if !bytes.Equal(cleaned, data) || len(result.Warnings) != 0 {
+		t.Errorf("Expected the file to be unchanged, got %v", chunkTypes(cleaned))
+	}
+}
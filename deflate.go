@@ -0,0 +1,729 @@
+package pngmetawebstrip
+
+import (
+	"encoding/binary"
+	"hash/adler32"
+	"math"
+	"math/bits"
+	"slices"
+)
+
+// Limits of the deflate format
+const (
+	deflateWindow   = 32768
+	deflateMinMatch = 3
+	deflateMaxMatch = 258
+)
+
+// Tuning of the optimal parser: hash chain candidates examined per
+// position, parsing rounds per block, and input bytes per block
+const (
+	deflateChainLimit = 1024
+	deflateIterations = 15
+	deflateBlockSize  = 1 << 16
+)
+
+// Base values and extra bits of the length symbols 257 to 285 and of the
+// distance symbols
+var (
+	lengthBase = [29]int{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31,
+		35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 258,
+	}
+	lengthExtra = [29]int{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0}
+	distBase    = [30]int{
+		1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193,
+		257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577,
+	}
+	distExtra = [30]int{0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13}
+)
+
+// Order in which the code length code lengths are stored
+var codeLengthOrder = [19]int{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+
+// lengthCode returns the index in lengthBase of the symbol of a match length
+func lengthCode(length int) int {
+	if length == deflateMaxMatch {
+		return 28
+	}
+	x := length - 3
+	if x < 8 {
+		return x
+	}
+	n := bits.Len(uint(x)) - 1
+	return 4*(n-1) + x>>(n-2)&3
+}
+
+// distCode returns the distance symbol of a match distance
+func distCode(dist int) int {
+	x := dist - 1
+	if x < 4 {
+		return x
+	}
+	n := bits.Len(uint(x)) - 1
+	return 2*n + x>>(n-1)&1
+}
+
+// lzToken is a literal byte when dist is 0, and a match of value bytes at
+// distance dist otherwise
+type lzToken struct {
+	value uint16
+	dist  uint16
+}
+
+// matchList holds, for each input position, the matches found in the
+// preceding window in order of increasing length. Every length up to that
+// of a match can use its distance.
+type matchList struct {
+	start   []int32 // Index in matches of the first match of each position, and the end
+	matches []lzToken
+}
+
+// at returns the matches found at position i
+func (m *matchList) at(i int) []lzToken {
+	return m.matches[m.start[i]:m.start[i+1]]
+}
+
+// hash3 hashes the three bytes starting at b[0] to 16 bits
+func hash3(b []byte) uint32 {
+	return (uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])) * 2654435761 >> 16
+}
+
+// findMatches walks hash chains to list the matches at every position of
+// data, stopping at a match of the maximum length
+func findMatches(data []byte) *matchList {
+	n := len(data)
+	m := &matchList{start: make([]int32, n+1)}
+	head := make([]int32, 1<<16)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, n)
+
+	for i := 0; i < n; i++ {
+		m.start[i] = int32(len(m.matches))
+		if i+deflateMinMatch > n {
+			continue
+		}
+
+		h := hash3(data[i:])
+		limit := min(deflateMaxMatch, n-i)
+		best := deflateMinMatch - 1
+		for c, chain := head[h], 0; c >= 0 && i-int(c) <= deflateWindow && chain < deflateChainLimit; c, chain = prev[c], chain+1 {
+			candidate := int(c)
+			if data[candidate+best] != data[i+best] {
+				continue
+			}
+			if length := matchLength(data[candidate:], data[i:i+limit]); length > best {
+				best = length
+				m.matches = append(m.matches, lzToken{value: uint16(length), dist: uint16(i - candidate)})
+				if length == limit {
+					break
+				}
+			}
+		}
+		prev[i], head[h] = head[h], int32(i)
+	}
+	m.start[n] = int32(len(m.matches))
+	return m
+}
+
+// matchLength returns the length of the common prefix of a and b, where a
+// is at least as long as b, comparing eight bytes at a time
+func matchLength(a, b []byte) int {
+	n := 0
+	for ; n+8 <= len(b); n += 8 {
+		if x := binary.LittleEndian.Uint64(a[n:]) ^ binary.LittleEndian.Uint64(b[n:]); x != 0 {
+			return n + bits.TrailingZeros64(x)/8
+		}
+	}
+	for n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// greedyParse covers data[from:to] with the longest match at each position,
+// or a literal where there is none
+func greedyParse(data []byte, m *matchList, from, to int) []lzToken {
+	var tokens []lzToken
+	for i := from; i < to; {
+		matches := m.at(i)
+		if len(matches) > 0 {
+			if longest := matches[len(matches)-1]; int(longest.value) <= to-i {
+				tokens = append(tokens, longest)
+				i += int(longest.value)
+				continue
+			}
+		}
+		tokens = append(tokens, lzToken{value: uint16(data[i])})
+		i++
+	}
+	return tokens
+}
+
+// symbolCosts estimates the size in bits of each literal/length and
+// distance symbol, including extra bits for lengths
+type symbolCosts struct {
+	literal  [286]float64
+	length   [deflateMaxMatch + 1]float64
+	distance [30]float64
+}
+
+// newSymbolCosts derives costs from the entropy of the symbols counted in h
+func newSymbolCosts(h *histogram) *symbolCosts {
+	c := &symbolCosts{}
+	entropy(h.literal[:], c.literal[:])
+	entropy(h.distance[:], c.distance[:])
+	for length := deflateMinMatch; length <= deflateMaxMatch; length++ {
+		code := lengthCode(length)
+		c.length[length] = c.literal[257+code] + float64(lengthExtra[code])
+	}
+	for code := range c.distance {
+		c.distance[code] += float64(distExtra[code])
+	}
+	return c
+}
+
+// entropy stores in cost the information content in bits of each symbol,
+// counting unused symbols as used once
+func entropy(freq []int, cost []float64) {
+	total := 0
+	for _, f := range freq {
+		total += f
+	}
+	log := math.Log2(float64(max(total, 1)))
+	for i, f := range freq {
+		cost[i] = log - math.Log2(float64(max(f, 1)))
+	}
+}
+
+// optimalParse covers data[from:to] with the tokens of the lowest total
+// cost, found as the shortest path through the positions
+func optimalParse(data []byte, m *matchList, from, to int, c *symbolCosts) []lzToken {
+	n := to - from
+	cost := make([]float64, n+1)
+	step := make([]lzToken, n+1)
+	for i := 1; i <= n; i++ {
+		cost[i] = math.Inf(1)
+	}
+
+	relax := func(i int, t lzToken, length int, size float64) {
+		if next := cost[i] + size; next < cost[i+length] {
+			cost[i+length], step[i+length] = next, t
+		}
+	}
+	for i := 0; i < n; i++ {
+		literal := data[from+i]
+		relax(i, lzToken{value: uint16(literal)}, 1, c.literal[literal])
+
+		matches := m.at(from + i)
+		if len(matches) > 0 && matches[len(matches)-1].value == deflateMaxMatch && n-i >= deflateMaxMatch {
+			// Inside long repetitions only the longest match is worth trying
+			longest := matches[len(matches)-1]
+			relax(i, longest, deflateMaxMatch, c.length[deflateMaxMatch]+c.distance[distCode(int(longest.dist))])
+			continue
+		}
+		shorter := deflateMinMatch
+		for _, match := range matches {
+			distance := c.distance[distCode(int(match.dist))]
+			for length := shorter; length <= min(int(match.value), n-i); length++ {
+				relax(i, lzToken{value: uint16(length), dist: match.dist}, length, c.length[length]+distance)
+			}
+			shorter = int(match.value) + 1
+		}
+	}
+
+	var tokens []lzToken
+	for i := n; i > 0; {
+		t := step[i]
+		tokens = append(tokens, t)
+		if t.dist == 0 {
+			i--
+		} else {
+			i -= int(t.value)
+		}
+	}
+	slices.Reverse(tokens)
+	return tokens
+}
+
+// histogram counts the literal/length and distance symbols of a block,
+// including its end of block symbol
+type histogram struct {
+	literal  [286]int
+	distance [30]int
+}
+
+// newHistogram counts the symbols encoding tokens
+func newHistogram(tokens []lzToken) *histogram {
+	h := &histogram{}
+	h.literal[256] = 1
+	for _, t := range tokens {
+		if t.dist == 0 {
+			h.literal[t.value]++
+			continue
+		}
+		h.literal[257+lengthCode(int(t.value))]++
+		h.distance[distCode(int(t.dist))]++
+	}
+	return h
+}
+
+// merge returns the counts of h and o as a single block
+func (h *histogram) merge(o *histogram) *histogram {
+	sum := *h
+	for s, f := range o.literal {
+		sum.literal[s] += f
+	}
+	for s, f := range o.distance {
+		sum.distance[s] += f
+	}
+	sum.literal[256]--
+	return &sum
+}
+
+// huffmanLengths returns the code lengths of a complete prefix code of at
+// most limit bits for the symbol frequencies. Unused symbols get no code,
+// except to make up the two symbols a complete code needs.
+func huffmanLengths(freq []int, limit int) []uint8 {
+	var used []int
+	for s, f := range freq {
+		if f > 0 {
+			used = append(used, s)
+		}
+	}
+	for s := 0; len(used) < 2; s++ {
+		if freq[s] == 0 {
+			used = append(used, s)
+		}
+	}
+	slices.SortStableFunc(used, func(a, b int) int { return freq[a] - freq[b] })
+
+	// Merge the two lightest nodes until one is left, taking them from the
+	// sorted leaves and the merged nodes, which are created in weight order
+	n := len(used)
+	weight := make([]int, 2*n-1)
+	parent := make([]int, 2*n-1)
+	for i, s := range used {
+		weight[i] = freq[s]
+	}
+	leaf, merged := 0, n
+	lightest := func(next int) int {
+		if leaf < n && (merged >= next || weight[leaf] <= weight[merged]) {
+			leaf++
+			return leaf - 1
+		}
+		merged++
+		return merged - 1
+	}
+	for next := n; next < 2*n-1; next++ {
+		a, b := lightest(next), lightest(next)
+		weight[next] = weight[a] + weight[b]
+		parent[a], parent[b] = next, next
+	}
+
+	depth := make([]int, 2*n-1)
+	lengths := make([]uint8, len(freq))
+	longest := 0
+	for i := 2*n - 3; i >= 0; i-- {
+		depth[i] = depth[parent[i]] + 1
+		if i < n {
+			lengths[used[i]] = uint8(depth[i])
+			longest = max(longest, depth[i])
+		}
+	}
+	if longest > limit {
+		limitLengths(lengths, used, limit)
+	}
+	return lengths
+}
+
+// limitLengths shortens the codes of a complete prefix code to limit bits,
+// lengthening shorter codes as little as possible until the code fits and
+// then shortening the longest ones while the code remains valid
+func limitLengths(lengths []uint8, used []int, limit int) {
+	full := 1 << limit
+	kraft := 0
+	for _, s := range used {
+		lengths[s] = uint8(min(int(lengths[s]), limit))
+		kraft += 1 << (limit - int(lengths[s]))
+	}
+
+	for kraft > full {
+		s := pickLength(lengths, used, func(l int) bool { return l < limit })
+		lengths[s]++
+		kraft -= 1 << (limit - int(lengths[s]))
+	}
+	for kraft < full {
+		s := pickLength(lengths, used, func(l int) bool { return kraft+1<<(limit-l) <= full })
+		kraft += 1 << (limit - int(lengths[s]))
+		lengths[s]--
+	}
+}
+
+// pickLength returns the used symbol with the longest code among those
+// whose length satisfies ok
+func pickLength(lengths []uint8, used []int, ok func(int) bool) int {
+	best := -1
+	for _, s := range used {
+		if ok(int(lengths[s])) && (best < 0 || lengths[s] > lengths[best]) {
+			best = s
+		}
+	}
+	return best
+}
+
+// canonicalCodes assigns the canonical codes of the code lengths, with
+// their bits reversed as they are written least significant bit first
+func canonicalCodes(lengths []uint8) []uint16 {
+	var count, next [16]int
+	for _, l := range lengths {
+		count[l]++
+	}
+	count[0] = 0
+	code := 0
+	for l := 1; l < 16; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+
+	codes := make([]uint16, len(lengths))
+	for s, l := range lengths {
+		if l > 0 {
+			codes[s] = bits.Reverse16(uint16(next[l])) >> (16 - l)
+			next[l]++
+		}
+	}
+	return codes
+}
+
+// Code lengths of the fixed Huffman codes
+var fixedLiteralLengths, fixedDistanceLengths = func() ([]uint8, []uint8) {
+	literal := make([]uint8, 288)
+	for s := range literal {
+		switch {
+		case s < 144:
+			literal[s] = 8
+		case s < 256:
+			literal[s] = 9
+		case s < 280:
+			literal[s] = 7
+		default:
+			literal[s] = 8
+		}
+	}
+	distance := make([]uint8, 30)
+	for s := range distance {
+		distance[s] = 5
+	}
+	return literal, distance
+}()
+
+// codeLength is a symbol of the code length alphabet, with the value of
+// its extra bits for the repeat symbols 16 to 18
+type codeLength struct {
+	symbol, extra int
+}
+
+// Extra bits of the code length repeat symbols 16 to 18
+var codeLengthExtra = [3]int{2, 3, 7}
+
+// runLengths encodes the code lengths of a dynamic block header, using
+// repeat symbols for runs
+func runLengths(lengths []uint8) []codeLength {
+	var out []codeLength
+	for i := 0; i < len(lengths); {
+		l := int(lengths[i])
+		run := 1
+		for i+run < len(lengths) && int(lengths[i+run]) == l {
+			run++
+		}
+		switch {
+		case l == 0 && run >= 11:
+			run = min(run, 138)
+			out = append(out, codeLength{18, run - 11})
+		case l == 0 && run >= 3:
+			run = min(run, 10)
+			out = append(out, codeLength{17, run - 3})
+		case l != 0 && run >= 4:
+			run = min(run-1, 6)
+			out = append(out, codeLength{l, 0}, codeLength{16, run - 3})
+			run++
+		default:
+			run = 1
+			out = append(out, codeLength{l, 0})
+		}
+		i += run
+	}
+	return out
+}
+
+// blockCodes holds the Huffman codes chosen for a block and its size
+type blockCodes struct {
+	literal, distance []uint8 // Code lengths
+	header            []codeLength
+	headerLengths     []uint8 // Code lengths of the code length alphabet
+	fixed             bool
+	stored            bool
+	bits              int // Size of the block in bits
+}
+
+// Largest number of bytes of a stored block
+const maxStoredBlock = 0xFFFF
+
+// planBlock picks the stored block or the fixed or dynamic Huffman codes
+// for size bytes whose symbols are counted in h, whichever is smallest
+func planBlock(size int, h *histogram) *blockCodes {
+	// Stored blocks are counted with the most padding before their length
+	stored := &blockCodes{stored: true, bits: 8 * size}
+	stored.bits += max(1, (size+maxStoredBlock-1)/maxStoredBlock) * (3 + 7 + 32)
+
+	fixed := &blockCodes{literal: fixedLiteralLengths, distance: fixedDistanceLengths, fixed: true, bits: 3}
+	fixed.bits += fixed.dataBits(h)
+
+	dynamic := &blockCodes{
+		literal:  huffmanLengths(h.literal[:], 15),
+		distance: huffmanLengths(h.distance[:], 15),
+	}
+	hlit, hdist := dynamic.counts()
+	dynamic.header = runLengths(append(slices.Clone(dynamic.literal[:hlit]), dynamic.distance[:hdist]...))
+	var freq [19]int
+	for _, c := range dynamic.header {
+		freq[c.symbol]++
+	}
+	dynamic.headerLengths = huffmanLengths(freq[:], 7)
+	dynamic.bits = 3 + 14 + 3*dynamic.hclen() + dynamic.dataBits(h)
+	for _, c := range dynamic.header {
+		dynamic.bits += int(dynamic.headerLengths[c.symbol])
+		if c.symbol >= 16 {
+			dynamic.bits += codeLengthExtra[c.symbol-16]
+		}
+	}
+
+	best := stored
+	for _, b := range []*blockCodes{fixed, dynamic} {
+		if b.bits < best.bits {
+			best = b
+		}
+	}
+	return best
+}
+
+// dataBits returns the size in bits of the symbols counted in h
+func (b *blockCodes) dataBits(h *histogram) int {
+	size := 0
+	for s, f := range h.literal {
+		size += f * int(b.literal[s])
+		if s > 256 {
+			size += f * lengthExtra[s-257]
+		}
+	}
+	for s, f := range h.distance {
+		size += f * (int(b.distance[s]) + distExtra[s])
+	}
+	return size
+}
+
+// counts returns the number of literal/length and distance codes stored in
+// a dynamic block header
+func (b *blockCodes) counts() (int, int) {
+	hlit, hdist := 257, 1
+	for s, l := range b.literal {
+		if l > 0 {
+			hlit = max(hlit, s+1)
+		}
+	}
+	for s, l := range b.distance {
+		if l > 0 {
+			hdist = max(hdist, s+1)
+		}
+	}
+	return hlit, hdist
+}
+
+// hclen returns the number of code length code lengths stored in a dynamic
+// block header
+func (b *blockCodes) hclen() int {
+	n := 4
+	for i, s := range codeLengthOrder {
+		if b.headerLengths[s] > 0 {
+			n = max(n, i+1)
+		}
+	}
+	return n
+}
+
+// bitWriter packs bits least significant bit first
+type bitWriter struct {
+	out  []byte
+	acc  uint64
+	nacc uint
+}
+
+// write appends the n low bits of v
+func (w *bitWriter) write(v, n int) {
+	w.acc |= uint64(v) << w.nacc
+	w.nacc += uint(n)
+	for w.nacc >= 8 {
+		w.out = append(w.out, byte(w.acc))
+		w.acc >>= 8
+		w.nacc -= 8
+	}
+}
+
+// flush pads the last byte with zero bits
+func (w *bitWriter) flush() {
+	if w.nacc > 0 {
+		w.write(0, int(8-w.nacc))
+	}
+}
+
+// blockHeader writes the final block flag and the block type
+func (w *bitWriter) blockHeader(final bool, blockType int) {
+	if final {
+		w.write(1, 1)
+	} else {
+		w.write(0, 1)
+	}
+	w.write(blockType, 2)
+}
+
+// writeStored writes raw bytes as stored blocks
+func (w *bitWriter) writeStored(raw []byte, final bool) {
+	for {
+		n := min(len(raw), maxStoredBlock)
+		w.blockHeader(final && n == len(raw), 0)
+		w.flush()
+		w.out = binary.LittleEndian.AppendUint16(w.out, uint16(n))
+		w.out = binary.LittleEndian.AppendUint16(w.out, ^uint16(n))
+		w.out = append(w.out, raw[:n]...)
+		if raw = raw[n:]; len(raw) == 0 {
+			return
+		}
+	}
+}
+
+// writeBlock writes a block of data with the codes of b
+func (w *bitWriter) writeBlock(data []byte, b *deflateBlock, final bool) {
+	codes, tokens := b.codes, b.tokens
+	if codes.stored {
+		w.writeStored(data[b.from:b.to], final)
+		return
+	}
+	w.writeHuffman(codes, tokens, final)
+}
+
+// writeHuffman writes tokens as a deflate block with the Huffman codes of b
+func (w *bitWriter) writeHuffman(b *blockCodes, tokens []lzToken, final bool) {
+	if b.fixed {
+		w.blockHeader(final, 1)
+	} else {
+		hlit, hdist := b.counts()
+		hclen := b.hclen()
+		w.blockHeader(final, 2)
+		w.write(hlit-257, 5)
+		w.write(hdist-1, 5)
+		w.write(hclen-4, 4)
+		for _, s := range codeLengthOrder[:hclen] {
+			w.write(int(b.headerLengths[s]), 3)
+		}
+		codes := canonicalCodes(b.headerLengths)
+		for _, c := range b.header {
+			w.write(int(codes[c.symbol]), int(b.headerLengths[c.symbol]))
+			if c.symbol >= 16 {
+				w.write(c.extra, codeLengthExtra[c.symbol-16])
+			}
+		}
+	}
+
+	literal, distance := canonicalCodes(b.literal), canonicalCodes(b.distance)
+	for _, t := range tokens {
+		if t.dist == 0 {
+			w.write(int(literal[t.value]), int(b.literal[t.value]))
+			continue
+		}
+		code := lengthCode(int(t.value))
+		w.write(int(literal[257+code]), int(b.literal[257+code]))
+		w.write(int(t.value)-lengthBase[code], lengthExtra[code])
+		code = distCode(int(t.dist))
+		w.write(int(distance[code]), int(b.distance[code]))
+		w.write(int(t.dist)-distBase[code], distExtra[code])
+	}
+	w.write(int(literal[256]), int(b.literal[256]))
+}
+
+// deflateBlock is a range of the input with its tokens and codes
+type deflateBlock struct {
+	from, to int
+	tokens   []lzToken
+	symbols  *histogram
+	codes    *blockCodes
+}
+
+// splitBlocks divides the greedy parse of data into blocks of
+// deflateBlockSize bytes, merging neighbours that are smaller as a single
+// block
+func splitBlocks(data []byte, m *matchList) []*deflateBlock {
+	var blocks []*deflateBlock
+	for from := 0; ; from += deflateBlockSize {
+		to := min(from+deflateBlockSize, len(data))
+		b := &deflateBlock{from: from, to: to, tokens: greedyParse(data, m, from, to)}
+		b.symbols = newHistogram(b.tokens)
+		b.codes = planBlock(to-from, b.symbols)
+
+		if n := len(blocks); n > 0 {
+			prev := blocks[n-1]
+			merged := &deflateBlock{
+				from: prev.from, to: to,
+				tokens:  append(prev.tokens, b.tokens...),
+				symbols: prev.symbols.merge(b.symbols),
+			}
+			if merged.codes = planBlock(to-prev.from, merged.symbols); merged.codes.bits < prev.codes.bits+b.codes.bits {
+				blocks[n-1], b = merged, nil
+			}
+		}
+		if b != nil {
+			blocks = append(blocks, b)
+		}
+		if to == len(data) {
+			return blocks
+		}
+	}
+}
+
+// optimalBlock returns the smallest encoding of a block found by repeated
+// optimal parsing, each round costing symbols by their frequencies in the
+// previous one, starting from the greedy parse of b
+func optimalBlock(data []byte, m *matchList, b *deflateBlock) *deflateBlock {
+	best := *b
+	symbols, size := b.symbols, b.codes.bits
+	for i := 0; i < deflateIterations; i++ {
+		tokens := optimalParse(data, m, b.from, b.to, newSymbolCosts(symbols))
+		symbols = newHistogram(tokens)
+		codes := planBlock(b.to-b.from, symbols)
+		if codes.bits < best.codes.bits {
+			best.tokens, best.symbols, best.codes = tokens, symbols, codes
+		}
+		if codes.bits == size {
+			break
+		}
+		size = codes.bits
+	}
+	return &best
+}
+
+// zlibOptimal compresses data to a zlib stream, spending far more time
+// than compress/flate to find a smaller encoding: blocks are split on a
+// greedy parse, then matches are chosen by optimal parsing with
+// iteratively refined symbol costs, in the manner of Zopfli
+func zlibOptimal(data []byte) []byte {
+	m := findMatches(data)
+	blocks := splitBlocks(data, m)
+	w := &bitWriter{out: []byte{0x78, 0xDA}}
+	for i, b := range blocks {
+		w.writeBlock(data, optimalBlock(data, m, b), i == len(blocks)-1)
+	}
+	w.flush()
+	return binary.BigEndian.AppendUint32(w.out, adler32.Checksum(data))
+}
@@ -0,0 +1,109 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// deflateInputs returns data exercising literals, short and long matches,
+// distant matches and several blocks
+func deflateInputs() map[string][]byte {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 5000)
+	rng.Read(random)
+
+	text := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 40)
+	noisy := make([]byte, 3*deflateBlockSize+123)
+	for i := range noisy {
+		noisy[i] = byte(i/300) + byte(rng.Intn(3))
+	}
+	distant := append(append(bytes.Clone(random), make([]byte, deflateWindow-len(random))...), random...)
+
+	return map[string][]byte{
+		"Empty":   {},
+		"Byte":    {42},
+		"Short":   []byte("abcab"),
+		"Random":  random,
+		"Text":    text,
+		"Zeros":   make([]byte, 2*deflateBlockSize+7),
+		"Noisy":   noisy,
+		"Distant": distant,
+	}
+}
+
+func TestZlibOptimal(t *testing.T) {
+	for name, data := range deflateInputs() {
+		t.Run(name, func(t *testing.T) {
+			encoded := zlibOptimal(data)
+			zr, err := zlib.NewReader(bytes.NewReader(encoded))
+			if err != nil {
+				t.Fatalf("Failed to open zlib stream: %v", err)
+			}
+			decoded, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatalf("Failed to inflate: %v", err)
+			}
+			if !bytes.Equal(decoded, data) {
+				t.Fatal("Inflated data differs from the input")
+			}
+
+			standard, err := deflate(data)
+			if err != nil {
+				t.Fatalf("Failed to deflate: %v", err)
+			}
+			if len(data) > 1000 && len(encoded) > len(standard) {
+				t.Errorf("Expected at most %d bytes as with compress/zlib, got %d", len(standard), len(encoded))
+			}
+		})
+	}
+}
+
+func TestHuffmanLengths(t *testing.T) {
+	// Fibonacci frequencies give an optimal code deeper than the limit
+	fibonacci := make([]int, 30)
+	fibonacci[0], fibonacci[1] = 1, 1
+	for i := 2; i < len(fibonacci); i++ {
+		fibonacci[i] = fibonacci[i-1] + fibonacci[i-2]
+	}
+
+	tests := []struct {
+		name  string
+		freq  []int
+		limit int
+		codes int
+	}{
+		{"Fibonacci", fibonacci, 15, 30},
+		{"Code lengths", fibonacci[:19], 7, 19},
+		{"Unused", []int{0, 5, 0, 0, 9, 1, 0}, 15, 3},
+		{"Single symbol", []int{0, 0, 7}, 15, 2},
+		{"No symbols", make([]int, 30), 15, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lengths := huffmanLengths(tt.freq, tt.limit)
+			kraft, codes := 0, 0
+			for s, l := range lengths {
+				if int(l) > tt.limit {
+					t.Errorf("Symbol %d has length %d over the limit of %d", s, l, tt.limit)
+				}
+				if tt.freq[s] > 0 && l == 0 {
+					t.Errorf("Used symbol %d has no code", s)
+				}
+				if l > 0 {
+					kraft += 1 << (tt.limit - int(l))
+					codes++
+				}
+			}
+			if kraft != 1<<tt.limit {
+				t.Errorf("Expected a complete code, Kraft sum is %d/%d", kraft, 1<<tt.limit)
+			}
+			if codes != tt.codes {
+				t.Errorf("Expected %d codes, got %d", tt.codes, codes)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package pngmetawebstrip
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sentinel errors reported by the chunk parser. Use errors.Is to test for
+// them; chunk level failures are wrapped in a *ChunkError.
+var (
+	ErrNotPNG        = errors.New("not a PNG")                  // Missing or invalid PNG signature
+	ErrTruncated     = errors.New("truncated data")             // Input ends inside a chunk
+	ErrBadCRC        = errors.New("invalid CRC")                // Chunk CRC does not match its contents
+	ErrChunkTooLarge = errors.New("chunk length exceeds limit") // Chunk length above 2^31-1
+	ErrInvalidChunk  = errors.New("invalid chunk data")         // Chunk contents are malformed
+	ErrTrailingData  = errors.New("data after IEND")            // Bytes follow IEND, see Options.RejectTrailingData
+	ErrPixelMismatch = errors.New("pixels differ")              // Output decodes differently from the input, see Options.Verify
+)
+
+// errWriterClosed is returned by Writer.Write after Close
+var errWriterClosed = errors.New("write to closed Writer")
+
+// ChunkError describes a failure tied to a specific chunk
+type ChunkError struct {
+	Type   string // Chunk type, empty when the header itself is incomplete
+	Offset int    // Offset of the chunk in the input
+
+	// CRC values, set when Err is ErrBadCRC
+	Expected uint32 // CRC calculated from the chunk type and data
+	Actual   uint32 // CRC stored in the chunk
+
+	Err error // Underlying error, wrapping one of the sentinel errors
+}
+
+func (e *ChunkError) Error() string {
+	msg := e.Err.Error()
+	if errors.Is(e.Err, ErrBadCRC) {
+		msg = fmt.Sprintf("%s (expected %08x, actual %08x)", msg, e.Expected, e.Actual)
+	}
+
+	if e.Type == "" {
+		return fmt.Sprintf("chunk at offset %d: %s", e.Offset, msg)
+	}
+	return fmt.Sprintf("chunk %s at offset %d: %s", e.Type, e.Offset, msg)
+}
+
+func (e *ChunkError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,106 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"testing"
+)
+
+func TestStripErrors(t *testing.T) {
+	data := buildMetadataPNG(t)
+
+	t.Run("Not a PNG", func(t *testing.T) {
+		for _, input := range [][]byte{nil, []byte("GIF89a\x00\x00\x00\x00")} {
+			_, _, err := Strip(input)
+			if !errors.Is(err, ErrNotPNG) {
+				t.Errorf("Expected ErrNotPNG, got %v", err)
+			}
+
+			var chunkErr *ChunkError
+			if errors.As(err, &chunkErr) {
+				t.Error("Signature errors should not be chunk errors")
+			}
+		}
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		_, _, err := Strip(data[:len(data)-20])
+
+		var chunkErr *ChunkError
+		if !errors.As(err, &chunkErr) || !errors.Is(err, ErrTruncated) {
+			t.Fatalf("Expected truncated *ChunkError, got %v", err)
+		}
+		if chunkErr.Type != "IDAT" {
+			t.Errorf("Expected chunk type IDAT, got %q", chunkErr.Type)
+		}
+	})
+
+	t.Run("Bad CRC", func(t *testing.T) {
+		corrupt := bytes.Clone(data)
+		// The first chunk after the signature is IHDR; break its CRC
+		binary.BigEndian.PutUint32(corrupt[29:33], 0xDEADBEEF)
+
+		_, _, err := Strip(corrupt)
+
+		var chunkErr *ChunkError
+		if !errors.As(err, &chunkErr) || !errors.Is(err, ErrBadCRC) {
+			t.Fatalf("Expected bad CRC *ChunkError, got %v", err)
+		}
+		if chunkErr.Type != "IHDR" || chunkErr.Offset != 8 {
+			t.Errorf("Expected IHDR at offset 8, got %s at %d", chunkErr.Type, chunkErr.Offset)
+		}
+		if chunkErr.Actual != 0xDEADBEEF || chunkErr.Expected != crc32.ChecksumIEEE(data[12:29]) {
+			t.Errorf("Unexpected CRC values: expected=%08x actual=%08x", chunkErr.Expected, chunkErr.Actual)
+		}
+	})
+
+	t.Run("Chunk too large", func(t *testing.T) {
+		// Every entry point classifies a length above 2^31-1 the same way
+		corrupt := append(bytes.Clone(data[:33]), 0x80, 0, 0, 0, 't', 'E', 'X', 't', 1, 2, 3, 4)
+
+		_, _, stripErr := Strip(corrupt)
+		_, readErr := io.ReadAll(NewReader(bytes.NewReader(corrupt)))
+		w := NewWriter(io.Discard)
+		_, writeErr := w.Write(corrupt)
+
+		for _, err := range []error{stripErr, readErr, writeErr} {
+			var chunkErr *ChunkError
+			if !errors.As(err, &chunkErr) || !errors.Is(err, ErrChunkTooLarge) {
+				t.Fatalf("Expected chunk too large *ChunkError, got %v", err)
+			}
+			if chunkErr.Type != "tEXt" || chunkErr.Offset != 33 {
+				t.Errorf("Expected tEXt at offset 33, got %s at %d", chunkErr.Type, chunkErr.Offset)
+			}
+		}
+	})
+
+	t.Run("Invalid chunk", func(t *testing.T) {
+		corrupt := buildPNG(data[8:33], makeChunk("fdAT", []byte{1}), makeChunk("IEND", nil))
+
+		_, _, err := Strip(corrupt)
+
+		var chunkErr *ChunkError
+		if !errors.As(err, &chunkErr) || !errors.Is(err, ErrInvalidChunk) {
+			t.Fatalf("Expected invalid chunk *ChunkError, got %v", err)
+		}
+		if chunkErr.Type != "fdAT" {
+			t.Errorf("Expected chunk type fdAT, got %q", chunkErr.Type)
+		}
+	})
+}
+
+func TestChunkErrorMessage(t *testing.T) {
+	err := &ChunkError{Type: "tEXt", Offset: 33, Expected: 0x12345678, Actual: 0, Err: ErrBadCRC}
+	expected := "chunk tEXt at offset 33: invalid CRC (expected 12345678, actual 00000000)"
+	if err.Error() != expected {
+		t.Errorf("Expected %q, got %q", expected, err.Error())
+	}
+
+	err = &ChunkError{Offset: 8, Err: ErrTruncated}
+	if err.Error() != "chunk at offset 8: truncated data" {
+		t.Errorf("Unexpected message %q", err.Error())
+	}
+}
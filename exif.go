@@ -0,0 +1,316 @@
+package pngmetawebstrip
+
+import (
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"slices"
+)
+
+// EXIF tags used by the stripper
+const (
+	tagOrientation = 0x0112
+	tagExifIFD     = 0x8769 // Pointer to the Exif sub-IFD
+)
+
+// exifTag identifies a tag that may be listed in Options.ExifTags
+type exifTag struct {
+	id      uint16
+	exifIFD bool // Stored in the Exif sub-IFD rather than IFD0
+}
+
+// Tags that may be kept by Options.ExifTags, by name. GPS data, serial
+// numbers, owner names and maker notes are deliberately absent.
+var exifTagNames = map[string]exifTag{
+	// IFD0
+	"ImageDescription": {0x010E, false},
+	"Make":             {0x010F, false},
+	"Model":            {0x0110, false},
+	"Orientation":      {tagOrientation, false},
+	"XResolution":      {0x011A, false},
+	"YResolution":      {0x011B, false},
+	"ResolutionUnit":   {0x0128, false},
+	"Software":         {0x0131, false},
+	"DateTime":         {0x0132, false},
+	"Artist":           {0x013B, false},
+	"Copyright":        {0x8298, false},
+	// Exif sub-IFD
+	"ExposureTime":      {0x829A, true},
+	"FNumber":           {0x829D, true},
+	"ISOSpeedRatings":   {0x8827, true},
+	"ExifVersion":       {0x9000, true},
+	"DateTimeOriginal":  {0x9003, true},
+	"DateTimeDigitized": {0x9004, true},
+	"FocalLength":       {0x920A, true},
+	"ColorSpace":        {0xA001, true},
+	"PixelXDimension":   {0xA002, true},
+	"PixelYDimension":   {0xA003, true},
+	"LensModel":         {0xA434, true},
+}
+
+// Sizes in bytes of the TIFF field types, indexed by type
+var tiffTypeSizes = [...]int{
+	1:  1, // BYTE
+	2:  1, // ASCII
+	3:  2, // SHORT
+	4:  4, // LONG
+	5:  8, // RATIONAL
+	6:  1, // SBYTE
+	7:  1, // UNDEFINED
+	8:  2, // SSHORT
+	9:  4, // SLONG
+	10: 8, // SRATIONAL
+	11: 4, // FLOAT
+	12: 8, // DOUBLE
+	13: 4, // IFD
+}
+
+// tiff is an EXIF payload: a TIFF header followed by image file directories
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// ifdEntry is a single field of an image file directory
+type ifdEntry struct {
+	tag    uint16
+	typ    uint16
+	count  uint32
+	offset int    // Offset of the value in the payload
+	value  []byte // Raw value bytes, in the payload's byte order
+}
+
+// parseTIFF checks the TIFF header of an eXIf payload
+func parseTIFF(data []byte) (*tiff, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("%w: EXIF header too short", ErrInvalidChunk)
+	}
+
+	t := &tiff{data: data}
+	switch string(data[0:4]) {
+	case "MM\x00\x2a":
+		t.order = binary.BigEndian
+	case "II\x2a\x00":
+		t.order = binary.LittleEndian
+	default:
+		return nil, fmt.Errorf("%w: invalid EXIF byte order mark", ErrInvalidChunk)
+	}
+	return t, nil
+}
+
+// firstIFD returns the offset of IFD0
+func (t *tiff) firstIFD() uint32 {
+	return t.order.Uint32(t.data[4:8])
+}
+
+// readIFD decodes the directory at offset and returns its entries and the
+// offset of the next directory
+func (t *tiff) readIFD(offset uint32) ([]ifdEntry, uint32, error) {
+	if uint64(offset)+2 > uint64(len(t.data)) {
+		return nil, 0, fmt.Errorf("%w: IFD offset %d out of range", ErrInvalidChunk, offset)
+	}
+
+	count := int(t.order.Uint16(t.data[offset:]))
+	start := int(offset) + 2
+	end := start + 12*count
+	if end+4 > len(t.data) {
+		return nil, 0, fmt.Errorf("%w: IFD at %d truncated", ErrInvalidChunk, offset)
+	}
+
+	entries := make([]ifdEntry, count)
+	for i := range entries {
+		field := t.data[start+12*i : start+12*i+12]
+		e := ifdEntry{
+			tag:   t.order.Uint16(field[0:2]),
+			typ:   t.order.Uint16(field[2:4]),
+			count: t.order.Uint32(field[4:8]),
+		}
+		if int(e.typ) >= len(tiffTypeSizes) || tiffTypeSizes[e.typ] == 0 {
+			return nil, 0, fmt.Errorf("%w: tag %04x has unknown type %d", ErrInvalidChunk, e.tag, e.typ)
+		}
+
+		// Values of up to 4 bytes are stored in the entry itself
+		size := uint64(e.count) * uint64(tiffTypeSizes[e.typ])
+		e.offset = start + 12*i + 8
+		if size > 4 {
+			e.offset = int(t.order.Uint32(field[8:12]))
+		}
+		if uint64(e.offset)+size > uint64(len(t.data)) {
+			return nil, 0, fmt.Errorf("%w: tag %04x value out of range", ErrInvalidChunk, e.tag)
+		}
+		e.value = t.data[e.offset : e.offset+int(size)]
+		entries[i] = e
+	}
+
+	return entries, t.order.Uint32(t.data[end:]), nil
+}
+
+// exifOrientation returns the Orientation tag (1-8) of an eXIf payload, or
+// 0 when it is missing or invalid
+func exifOrientation(data []byte) int {
+	t, err := parseTIFF(data)
+	if err != nil {
+		return 0
+	}
+	entries, _, err := t.readIFD(t.firstIFD())
+	if err != nil {
+		return 0
+	}
+
+	for _, e := range entries {
+		if e.tag == tagOrientation && e.typ == 3 && e.count == 1 {
+			if v := int(t.order.Uint16(e.value)); v >= 1 && v <= 8 {
+				return v
+			}
+		}
+	}
+	return 0
+}
+
+// resetOrientation returns a copy of an eXIf payload with the Orientation
+// tag set to 1 (normal)
+func resetOrientation(data []byte) []byte {
+	t, err := parseTIFF(data)
+	if err != nil {
+		return data
+	}
+	entries, _, err := t.readIFD(t.firstIFD())
+	if err != nil {
+		return data
+	}
+
+	reset := make([]byte, len(data))
+	copy(reset, data)
+	for _, e := range entries {
+		if e.tag == tagOrientation && e.typ == 3 && e.count == 1 {
+			t.order.PutUint16(reset[e.offset:], 1)
+		}
+	}
+	return reset
+}
+
+// processExif writes an eXIf chunk reduced to the tags allowed by
+// Options.ExifTags in place of a chunk the policy removes. The chunk is
+// removed entirely when it cannot be parsed or holds none of the tags.
+func (s *stripper) processExif(chunk []byte) error {
+	info := s.currentChunk()
+	data, err := filterExif(chunk[8:len(chunk)-4], s.policy.opts.ExifTags)
+	if err != nil {
+		s.warn("eXIf", info.Offset, "malformed EXIF, chunk removed: %v", err)
+	}
+	if data == nil {
+		trackRemovedChunk(s.result, "eXIf", len(chunk))
+		return nil
+	}
+
+	filtered := buildChunk("eXIf", data)
+	info.Kept = true
+	info.Reason = ReasonExifTags
+	trackRemovedChunk(s.result, "eXIf", len(chunk)-len(filtered))
+	return s.write(filtered)
+}
+
+// filterExif rebuilds an eXIf payload with only the named tags, keeping the
+// byte order of the original. It returns nil when none of the tags are
+// present and an error when the payload cannot be parsed.
+func filterExif(data []byte, names []string) ([]byte, error) {
+	t, err := parseTIFF(data)
+	if err != nil {
+		return nil, err
+	}
+	ifd0, _, err := t.readIFD(t.firstIFD())
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := map[exifTag]bool{}
+	for _, name := range names {
+		if tag, ok := exifTagNames[name]; ok {
+			allowed[tag] = true
+		}
+	}
+
+	var main, sub []ifdEntry
+	for _, e := range ifd0 {
+		if e.tag != tagExifIFD {
+			if allowed[exifTag{e.tag, false}] {
+				main = append(main, e)
+			}
+			continue
+		}
+
+		// The pointer is a single LONG or IFD value
+		if e.typ != 4 && e.typ != 13 || e.count != 1 {
+			return nil, fmt.Errorf("%w: invalid Exif IFD pointer", ErrInvalidChunk)
+		}
+		entries, _, err := t.readIFD(t.order.Uint32(e.value))
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if allowed[exifTag{e.tag, true}] {
+				sub = append(sub, e)
+			}
+		}
+	}
+	if len(main) == 0 && len(sub) == 0 {
+		return nil, nil
+	}
+
+	// The sub-IFD follows IFD0 and its values
+	if len(sub) > 0 {
+		pointer := make([]byte, 4)
+		t.order.PutUint32(pointer, uint32(8+ifdSize(main)+12))
+		main = append(main, ifdEntry{tag: tagExifIFD, typ: 4, count: 1, value: pointer})
+	}
+
+	out := make([]byte, 8, 8+ifdSize(main)+ifdSize(sub))
+	copy(out, data[0:4])
+	t.order.PutUint32(out[4:8], 8)
+	out = t.appendIFD(out, main)
+	if len(sub) > 0 {
+		out = t.appendIFD(out, sub)
+	}
+	return out, nil
+}
+
+// ifdSize returns the number of bytes appendIFD writes for entries
+func ifdSize(entries []ifdEntry) int {
+	size := 2 + 12*len(entries) + 4
+	for _, e := range entries {
+		if len(e.value) > 4 {
+			size += len(e.value) + len(e.value)%2
+		}
+	}
+	return size
+}
+
+// appendIFD appends a directory holding entries, sorted by tag, followed by
+// the values that do not fit in the entries. Offsets are relative to the
+// start of out, which must begin with the TIFF header.
+func (t *tiff) appendIFD(out []byte, entries []ifdEntry) []byte {
+	slices.SortFunc(entries, func(a, b ifdEntry) int { return cmp.Compare(a.tag, b.tag) })
+
+	start := len(out)
+	valueOffset := start + 2 + 12*len(entries) + 4
+	out = append(out, make([]byte, ifdSize(entries))...)
+
+	t.order.PutUint16(out[start:], uint16(len(entries)))
+	for i, e := range entries {
+		field := out[start+2+12*i:]
+		t.order.PutUint16(field[0:2], e.tag)
+		t.order.PutUint16(field[2:4], e.typ)
+		t.order.PutUint32(field[4:8], e.count)
+
+		if len(e.value) <= 4 {
+			copy(field[8:12], e.value)
+			continue
+		}
+		t.order.PutUint32(field[8:12], uint32(valueOffset))
+		copy(out[valueOffset:], e.value)
+		valueOffset += len(e.value) + len(e.value)%2
+	}
+
+	// The next IFD offset is left zero
+	return out
+}
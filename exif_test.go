@@ -0,0 +1,246 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// exifPayload builds an eXIf payload whose IFD0 holds an Orientation tag and
+// a Software tag stored outside the entry
+func exifPayload(order binary.AppendByteOrder, orientation uint16) []byte {
+	header := "MM\x00\x2a"
+	if order == binary.LittleEndian {
+		header = "II\x2a\x00"
+	}
+
+	data := []byte(header)
+	data = order.AppendUint32(data, 8)
+	data = order.AppendUint16(data, 2)
+	// Orientation, SHORT, 1 value stored in the entry
+	data = order.AppendUint16(data, tagOrientation)
+	data = order.AppendUint16(data, 3)
+	data = order.AppendUint32(data, 1)
+	data = order.AppendUint16(data, orientation)
+	data = order.AppendUint16(data, 0)
+	// Software, ASCII, 8 bytes stored after the IFD
+	data = order.AppendUint16(data, 0x0131)
+	data = order.AppendUint16(data, 2)
+	data = order.AppendUint32(data, 8)
+	data = order.AppendUint32(data, 38)
+	data = order.AppendUint32(data, 0) // No next IFD
+	return append(data, "Camera\x00\x00"...)
+}
+
+func TestExifOrientation(t *testing.T) {
+	for _, order := range []binary.AppendByteOrder{binary.BigEndian, binary.LittleEndian} {
+		for orientation := uint16(1); orientation <= 8; orientation++ {
+			if got := exifOrientation(exifPayload(order, orientation)); got != int(orientation) {
+				t.Errorf("%v: expected orientation %d, got %d", order, orientation, got)
+			}
+		}
+
+		reset := resetOrientation(exifPayload(order, 6))
+		if got := exifOrientation(reset); got != 1 {
+			t.Errorf("%v: expected reset orientation 1, got %d", order, got)
+		}
+	}
+
+	for _, data := range [][]byte{nil, []byte("MM\x00\x2a\x00\x00\x00\xFF"), exifPayload(binary.BigEndian, 9)} {
+		if got := exifOrientation(data); got != 0 {
+			t.Errorf("Expected no orientation for %q, got %d", data, got)
+		}
+	}
+}
+
+// cameraExif builds an eXIf payload in the given byte order with tags in
+// IFD0, a GPS IFD and an Exif sub-IFD
+func cameraExif(order binary.ByteOrder) []byte {
+	header := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	if order == binary.LittleEndian {
+		header = []byte("II\x2a\x00\x08\x00\x00\x00")
+	}
+	t := &tiff{data: header, order: order}
+
+	short := func(v uint16) []byte {
+		b := make([]byte, 2)
+		order.PutUint16(b, v)
+		return b
+	}
+	long := func(v uint32) []byte {
+		b := make([]byte, 4)
+		order.PutUint32(b, v)
+		return b
+	}
+	ascii := func(s string) ifdEntry {
+		return ifdEntry{typ: 2, count: uint32(len(s) + 1), value: append([]byte(s), 0)}
+	}
+	tag := func(id uint16, e ifdEntry) ifdEntry {
+		e.tag = id
+		return e
+	}
+
+	ifd0 := []ifdEntry{
+		tag(0x010F, ascii("Example Camera Co.")),
+		tag(tagOrientation, ifdEntry{typ: 3, count: 1, value: short(6)}),
+		tag(0x8298, ascii("(c) Example")),
+		tag(0x8825, ifdEntry{typ: 4, count: 1, value: long(0)}), // GPS IFD, patched below
+		tag(tagExifIFD, ifdEntry{typ: 4, count: 1, value: long(0)}),
+	}
+	gps := []ifdEntry{
+		tag(0x0002, ifdEntry{typ: 5, count: 3, value: make([]byte, 24)}), // GPSLatitude
+	}
+	exif := []ifdEntry{
+		tag(0x9003, ascii("2024:01:01 12:00:00")),
+		tag(0xA001, ifdEntry{typ: 3, count: 1, value: short(1)}),
+		tag(0xA431, ascii("SERIAL-123456")), // BodySerialNumber
+	}
+
+	gpsOffset := 8 + ifdSize(ifd0)
+	order.PutUint32(ifd0[3].value, uint32(gpsOffset))
+	order.PutUint32(ifd0[4].value, uint32(gpsOffset+ifdSize(gps)))
+
+	out := t.appendIFD(header, ifd0)
+	out = t.appendIFD(out, gps)
+	return t.appendIFD(out, exif)
+}
+
+// exifTags returns the tags of IFD0 and the Exif sub-IFD of a payload
+func exifTags(t *testing.T, data []byte) map[uint16][]byte {
+	t.Helper()
+
+	tf, err := parseTIFF(data)
+	if err != nil {
+		t.Fatalf("Failed to parse EXIF: %v", err)
+	}
+	ifd0, _, err := tf.readIFD(tf.firstIFD())
+	if err != nil {
+		t.Fatalf("Failed to read IFD0: %v", err)
+	}
+
+	tags := map[uint16][]byte{}
+	for _, e := range ifd0 {
+		tags[e.tag] = e.value
+		if e.tag == tagExifIFD {
+			sub, _, err := tf.readIFD(tf.order.Uint32(e.value))
+			if err != nil {
+				t.Fatalf("Failed to read Exif IFD: %v", err)
+			}
+			for _, e := range sub {
+				tags[e.tag] = e.value
+			}
+		}
+	}
+	return tags
+}
+
+func TestFilterExif(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		original := cameraExif(order)
+		filtered, err := filterExif(original, []string{"Orientation", "ColorSpace", "Copyright", "GPSLatitude"})
+		if err != nil {
+			t.Fatalf("%v: failed to filter EXIF: %v", order, err)
+		}
+		if len(filtered) >= len(original) || !bytes.Equal(filtered[0:4], original[0:4]) {
+			t.Errorf("%v: expected a smaller payload in the same byte order", order)
+		}
+
+		before, after := exifTags(t, original), exifTags(t, filtered)
+		for _, tag := range []uint16{tagOrientation, 0xA001, 0x8298} {
+			if !bytes.Equal(before[tag], after[tag]) {
+				t.Errorf("%v: tag %04x changed from %q to %q", order, tag, before[tag], after[tag])
+			}
+		}
+		for _, tag := range []uint16{0x010F, 0x8825, 0x9003, 0xA431} {
+			if after[tag] != nil {
+				t.Errorf("%v: expected tag %04x to be removed", order, tag)
+			}
+		}
+		if exifOrientation(filtered) != 6 {
+			t.Errorf("%v: orientation not readable from the filtered payload", order)
+		}
+	}
+
+	// Without sub-IFD tags no Exif pointer is written
+	filtered, err := filterExif(cameraExif(binary.BigEndian), []string{"Orientation"})
+	if err != nil {
+		t.Fatalf("Failed to filter EXIF: %v", err)
+	}
+	if tags := exifTags(t, filtered); len(tags) != 1 {
+		t.Errorf("Expected only the Orientation tag, got %d tags", len(tags))
+	}
+
+	if filtered, err := filterExif(cameraExif(binary.BigEndian), []string{"Artist"}); filtered != nil || err != nil {
+		t.Errorf("Expected nil without matching tags, got %q, %v", filtered, err)
+	}
+	if _, err := filterExif([]byte("MM\x00\x2a\x00\x00\x01\x00"), []string{"Orientation"}); err == nil {
+		t.Error("Expected an error for an out of range IFD")
+	}
+}
+
+func TestExifTagsOption(t *testing.T) {
+	opts := DefaultOptions()
+	opts.ExifTags = []string{"Orientation", "ColorSpace", "Copyright"}
+	onlyMake, err := filterExif(cameraExif(binary.BigEndian), []string{"Make"})
+	if err != nil {
+		t.Fatalf("Failed to filter EXIF: %v", err)
+	}
+
+	// Exif sub-IFD pointer of the given type and count in IFD0
+	pointer := func(typ uint16, count uint32) []byte {
+		tf := &tiff{data: []byte("MM\x00\x2a\x00\x00\x00\x08"), order: binary.BigEndian}
+		return tf.appendIFD(tf.data, []ifdEntry{{tag: tagExifIFD, typ: typ, count: count, value: []byte{0, 8}}})
+	}
+	ifdTyped := cameraExif(binary.BigEndian)
+	binary.BigEndian.PutUint16(ifdTyped[8+2+12*3+2:], 13) // Exif IFD pointer as type IFD
+
+	tests := []struct {
+		name     string
+		exif     []byte
+		kept     bool
+		warnings int
+	}{
+		{"Camera EXIF", cameraExif(binary.LittleEndian), true, 0},
+		{"No allowed tags", onlyMake, false, 0},
+		{"Malformed", []byte("not exif at all"), false, 1},
+		{"SHORT Exif pointer", pointer(3, 1), false, 1},
+		{"Empty Exif pointer", pointer(4, 0), false, 1},
+		{"IFD-typed Exif pointer", ifdTyped, true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := buildICCPNG(t, makeChunk("eXIf", tt.exif))
+			cleaned, result, err := StripWithOptions(data, opts)
+			if err != nil {
+				t.Fatalf("Failed to process PNG: %v", err)
+			}
+
+			if hasChunk(cleaned, "eXIf") != tt.kept {
+				t.Errorf("Expected eXIf kept=%v, got %v", tt.kept, chunkTypes(cleaned))
+			}
+			if len(result.Warnings) != tt.warnings {
+				t.Errorf("Expected %d warnings, got %v", tt.warnings, result.Warnings)
+			}
+			if result.Total != len(data)-len(cleaned) || result.Removed.ExifData != result.Total {
+				t.Errorf("Unexpected savings: exif=%d total=%d", result.Removed.ExifData, result.Total)
+			}
+
+			info := result.Chunks[1]
+			if info.Kept != tt.kept || tt.kept && info.Reason != ReasonExifTags {
+				t.Errorf("Unexpected chunk log entry %+v", info)
+			}
+		})
+	}
+
+	// The Drop list and KeepExif take precedence over the allowlist
+	data := buildICCPNG(t, makeChunk("eXIf", cameraExif(binary.BigEndian)))
+	opts.Drop = []string{"eXIf"}
+	if cleaned, _, _ := StripWithOptions(data, opts); hasChunk(cleaned, "eXIf") {
+		t.Error("Expected the Drop list to remove eXIf")
+	}
+	opts.Drop, opts.KeepExif = nil, true
+	if cleaned, _, _ := StripWithOptions(data, opts); !bytes.Equal(cleaned, data) {
+		t.Error("Expected KeepExif to keep eXIf verbatim")
+	}
+}
@@ -0,0 +1,504 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Largest decompressed ICC profile that is inspected. Bigger profiles are
+// kept verbatim.
+const maxProfileSize = 64 << 20
+
+// iccpChunk is the decoded form of an iCCP chunk
+type iccpChunk struct {
+	name    string // Profile name, 1-79 Latin-1 characters
+	profile []byte // Decompressed ICC profile
+}
+
+// parseICCP decodes the data of an iCCP chunk
+func parseICCP(data []byte) (*iccpChunk, error) {
+	sep := bytes.IndexByte(data, 0)
+	if sep < 1 || sep > 79 || sep+2 > len(data) {
+		return nil, fmt.Errorf("%w: malformed iCCP profile name", ErrInvalidChunk)
+	}
+	if data[sep+1] != 0 {
+		return nil, fmt.Errorf("%w: unknown iCCP compression method %d", ErrInvalidChunk, data[sep+1])
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(data[sep+2:]))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidChunk, err)
+	}
+	defer zr.Close()
+
+	profile, err := io.ReadAll(io.LimitReader(zr, maxProfileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidChunk, err)
+	}
+	if len(profile) > maxProfileSize {
+		return nil, fmt.Errorf("%w: ICC profile larger than %d bytes", ErrInvalidChunk, maxProfileSize)
+	}
+
+	return &iccpChunk{name: string(data[:sep]), profile: profile}, nil
+}
+
+// processICCP writes an sRGB chunk in place of an iCCP chunk whose profile
+// is equivalent to sRGB, and a minimized profile or the chunk itself otherwise
+func (s *stripper) processICCP(chunk []byte) error {
+	iccp, err := parseICCP(chunk[8 : len(chunk)-4])
+	if err != nil {
+		// Undecodable profiles are kept verbatim
+		return s.write(chunk)
+	}
+
+	if s.policy.opts.ReplaceSRGBProfile && isSRGBProfile(iccp.profile) {
+		return s.replaceWithSRGB(chunk, iccp)
+	}
+	if s.policy.opts.MinimizeProfile {
+		return s.writeMinimizedProfile(chunk, iccp)
+	}
+	return s.write(chunk)
+}
+
+// replaceWithSRGB writes an sRGB chunk carrying the profile's rendering
+// intent in place of the iCCP chunk
+func (s *stripper) replaceWithSRGB(chunk []byte, iccp *iccpChunk) error {
+	info := s.currentChunk()
+	info.Kept = false
+	info.Reason = ReasonSRGB
+
+	saved := len(chunk)
+	if !s.hasSRGB {
+		replacement := buildChunk("sRGB", []byte{profileRenderingIntent(iccp.profile)})
+		if err := s.write(replacement); err != nil {
+			return err
+		}
+		s.hasSRGB = true
+		saved -= len(replacement)
+	}
+
+	s.result.Optimized.ColorProfile += saved
+	s.result.Total += saved
+	return nil
+}
+
+// writeMinimizedProfile writes the iCCP chunk with non-essential tags
+// removed and maximum compression, unless that does not make it smaller
+func (s *stripper) writeMinimizedProfile(chunk []byte, iccp *iccpChunk) error {
+	profile := minimizeProfile(iccp.profile)
+	if profile == nil {
+		return s.write(chunk)
+	}
+
+	data, err := compressProfile(iccp.name, profile)
+	if err != nil || len(data)+12 >= len(chunk) {
+		return s.write(chunk)
+	}
+
+	minimized := buildChunk("iCCP", data)
+	saved := len(chunk) - len(minimized)
+	s.result.Optimized.ProfileMinimization += saved
+	s.result.Total += saved
+	return s.write(minimized)
+}
+
+// iccTags returns the tag table of an ICC profile, mapping each signature
+// to its data. Malformed profiles yield nil.
+func iccTags(profile []byte) map[string][]byte {
+	if len(profile) < 132 || string(profile[36:40]) != "acsp" {
+		return nil
+	}
+
+	count := int(binary.BigEndian.Uint32(profile[128:132]))
+	if count > (len(profile)-132)/12 {
+		return nil
+	}
+
+	tags := make(map[string][]byte, count)
+	for i := 0; i < count; i++ {
+		entry := profile[132+12*i:]
+		offset := int(binary.BigEndian.Uint32(entry[4:8]))
+		size := int(binary.BigEndian.Uint32(entry[8:12]))
+		if offset < 0 || size < 0 || offset > len(profile) || size > len(profile)-offset {
+			return nil
+		}
+		tags[string(entry[0:4])] = profile[offset : offset+size]
+	}
+	return tags
+}
+
+// D50-adapted sRGB colorants (rXYZ, gXYZ, bXYZ) as found in sRGB profiles
+var srgbColorants = map[string][3]float64{
+	"rXYZ": {0.4361, 0.2225, 0.0139},
+	"gXYZ": {0.3851, 0.7169, 0.0971},
+	"bXYZ": {0.1431, 0.0606, 0.7141},
+}
+
+// isSRGBProfile reports whether an ICC profile describes the sRGB color
+// space. The colorant and tone curve tags must match sRGB; profiles that also
+// carry lookup tables are only accepted when described as sRGB IEC61966-2.1,
+// as the tables take precedence over the matrix in color managed decoders.
+func isSRGBProfile(profile []byte) bool {
+	tags := iccTags(profile)
+	if tags == nil || string(profile[16:20]) != "RGB " || string(profile[20:24]) != "XYZ " {
+		return false
+	}
+
+	for sig, expected := range srgbColorants {
+		xyz, ok := parseXYZ(tags[sig])
+		if !ok {
+			return false
+		}
+		for i := range xyz {
+			if math.Abs(xyz[i]-expected[i]) > 0.002 {
+				return false
+			}
+		}
+	}
+
+	for _, sig := range []string{"rTRC", "gTRC", "bTRC"} {
+		if !isSRGBCurve(tags[sig]) {
+			return false
+		}
+	}
+
+	for _, sig := range []string{"A2B0", "A2B1", "A2B2", "B2A0", "B2A1", "B2A2"} {
+		if _, ok := tags[sig]; ok {
+			return bytes.Contains(profileDescription(tags), []byte("61966-2"))
+		}
+	}
+	return true
+}
+
+// profileRenderingIntent returns the rendering intent from the profile header
+func profileRenderingIntent(profile []byte) byte {
+	intent := binary.BigEndian.Uint32(profile[64:68])
+	if intent > 3 {
+		return 0 // Perceptual
+	}
+	return byte(intent)
+}
+
+// profileDescription returns the raw text of the description tag, which is
+// enough to match ASCII names in both textDescriptionType and
+// multiLocalizedUnicodeType
+func profileDescription(tags map[string][]byte) []byte {
+	desc := tags["desc"]
+	if len(desc) < 12 {
+		return nil
+	}
+	// Drop UTF-16 zero bytes so ASCII names can be matched directly
+	return bytes.ReplaceAll(desc[8:], []byte{0}, nil)
+}
+
+// parseXYZ decodes an XYZType tag holding a single value
+func parseXYZ(tag []byte) ([3]float64, bool) {
+	var xyz [3]float64
+	if len(tag) < 20 || string(tag[0:4]) != "XYZ " {
+		return xyz, false
+	}
+	for i := range xyz {
+		xyz[i] = s15Fixed16(tag[8+4*i:])
+	}
+	return xyz, true
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// srgbEOTF converts an encoded sRGB value to linear light
+func srgbEOTF(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// isSRGBCurve reports whether a curveType or parametricCurveType tag follows
+// the sRGB transfer function within 8-bit precision
+func isSRGBCurve(tag []byte) bool {
+	curve, ok := parseCurve(tag)
+	if !ok {
+		return false
+	}
+
+	for i := 0; i <= 64; i++ {
+		x := float64(i) / 64
+		if math.Abs(curve(x)-srgbEOTF(x)) > 1.0/256 {
+			return false
+		}
+	}
+	return true
+}
+
+// parseCurve decodes a curveType or parametricCurveType tag into a function
+// on [0, 1]
+func parseCurve(tag []byte) (func(float64) float64, bool) {
+	if len(tag) < 12 {
+		return nil, false
+	}
+
+	switch string(tag[0:4]) {
+	case "curv":
+		count := int(binary.BigEndian.Uint32(tag[8:12]))
+		if count > (len(tag)-12)/2 {
+			return nil, false
+		}
+		switch count {
+		case 0:
+			return func(x float64) float64 { return x }, true
+		case 1:
+			gamma := float64(binary.BigEndian.Uint16(tag[12:14])) / 256
+			return func(x float64) float64 { return math.Pow(x, gamma) }, true
+		}
+
+		table := make([]float64, count)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(tag[12+2*i:])) / 65535
+		}
+		return func(x float64) float64 {
+			// Linear interpolation between table entries
+			pos := x * float64(count-1)
+			i := int(pos)
+			if i >= count-1 {
+				return table[count-1]
+			}
+			frac := pos - float64(i)
+			return table[i]*(1-frac) + table[i+1]*frac
+		}, true
+
+	case "para":
+		// Parameter counts for function types 0-4
+		counts := []int{1, 3, 4, 5, 7}
+		fn := int(binary.BigEndian.Uint16(tag[8:10]))
+		if fn >= len(counts) || len(tag) < 12+4*counts[fn] {
+			return nil, false
+		}
+
+		var p [7]float64
+		for i := 0; i < counts[fn]; i++ {
+			p[i] = s15Fixed16(tag[12+4*i:])
+		}
+		g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+
+		return func(x float64) float64 {
+			switch fn {
+			case 0:
+				return math.Pow(x, g)
+			case 1:
+				if x >= -b/a {
+					return math.Pow(a*x+b, g)
+				}
+				return 0
+			case 2:
+				if x >= -b/a {
+					return math.Pow(a*x+b, g) + c
+				}
+				return c
+			case 3:
+				if x >= d {
+					return math.Pow(a*x+b, g)
+				}
+				return c * x
+			default:
+				if x >= d {
+					return math.Pow(a*x+b, g) + e
+				}
+				return c*x + f
+			}
+		}, true
+	}
+
+	return nil, false
+}
+
+// Tags needed to transform colors. Everything else (descriptions in many
+// languages, copyright, measurement and viewing conditions, vendor private
+// tags) is informational and dropped when minimizing a profile.
+var colorTransformTags = map[string]bool{
+	// Matrix/TRC profiles
+	"rXYZ": true, "gXYZ": true, "bXYZ": true,
+	"rTRC": true, "gTRC": true, "bTRC": true, "kTRC": true,
+	"wtpt": true, "bkpt": true, "chad": true, "lumi": true,
+	// Lookup table profiles
+	"A2B0": true, "A2B1": true, "A2B2": true, "A2B3": true,
+	"B2A0": true, "B2A1": true, "B2A2": true, "B2A3": true,
+	"D2B0": true, "D2B1": true, "D2B2": true, "D2B3": true,
+	"B2D0": true, "B2D1": true, "B2D2": true, "B2D3": true,
+	// Coding-independent code points
+	"cicp": true,
+}
+
+// minimizeProfile rebuilds an ICC profile with only the tags needed to
+// transform colors and a short description. It returns nil when the profile
+// cannot be parsed.
+func minimizeProfile(profile []byte) []byte {
+	tags := iccTags(profile)
+	if tags == nil {
+		return nil
+	}
+
+	type entry struct {
+		sig  string
+		data []byte
+	}
+
+	// Keep the original tag order for stable output
+	count := int(binary.BigEndian.Uint32(profile[128:132]))
+	var entries []entry
+	for i := 0; i < count; i++ {
+		sig := string(profile[132+12*i : 136+12*i])
+		if colorTransformTags[sig] {
+			entries = append(entries, entry{sig, tags[sig]})
+		}
+	}
+	if desc := minimalDescription(profile, tags); desc != nil {
+		entries = append(entries, entry{"desc", desc})
+	}
+
+	out := make([]byte, 132+12*len(entries), len(profile))
+	copy(out, profile[:128])
+	binary.BigEndian.PutUint32(out[128:132], uint32(len(entries)))
+
+	// Tag data is 4-byte aligned; identical data is stored once, as profiles
+	// commonly share one curve between rTRC, gTRC and bTRC
+	offsets := map[string]int{}
+	for i, e := range entries {
+		offset, ok := offsets[string(e.data)]
+		if !ok {
+			for len(out)%4 != 0 {
+				out = append(out, 0)
+			}
+			offset = len(out)
+			offsets[string(e.data)] = offset
+			out = append(out, e.data...)
+		}
+
+		table := out[132+12*i:]
+		copy(table[0:4], e.sig)
+		binary.BigEndian.PutUint32(table[4:8], uint32(offset))
+		binary.BigEndian.PutUint32(table[8:12], uint32(len(e.data)))
+	}
+	for len(out)%4 != 0 {
+		out = append(out, 0)
+	}
+
+	binary.BigEndian.PutUint32(out[0:4], uint32(len(out)))
+	setProfileID(out)
+	return out
+}
+
+// setProfileID recalculates the MD5 profile ID of a version 4 profile. The
+// ID is left zero, meaning not calculated, when the original had none.
+func setProfileID(profile []byte) {
+	id := profile[84:100]
+	if bytes.Equal(id, make([]byte, 16)) {
+		return
+	}
+
+	// The ID covers the profile with flags, rendering intent and ID zeroed
+	tmp := bytes.Clone(profile)
+	clear(tmp[44:48])
+	clear(tmp[64:68])
+	clear(tmp[84:100])
+	sum := md5.Sum(tmp)
+	copy(id, sum[:])
+}
+
+// minimalDescription returns a description tag holding only the first
+// description found, in the tag type used by the profile version
+func minimalDescription(profile []byte, tags map[string][]byte) []byte {
+	text := descriptionText(tags["desc"])
+	if text == "" {
+		return nil
+	}
+
+	if profile[8] >= 4 {
+		// multiLocalizedUnicodeType with a single en-US record
+		utf16 := make([]byte, 0, 2*len(text))
+		for _, r := range text {
+			utf16 = binary.BigEndian.AppendUint16(utf16, uint16(r))
+		}
+
+		desc := []byte("mluc\x00\x00\x00\x00")
+		desc = binary.BigEndian.AppendUint32(desc, 1)  // Record count
+		desc = binary.BigEndian.AppendUint32(desc, 12) // Record size
+		desc = append(desc, "enUS"...)
+		desc = binary.BigEndian.AppendUint32(desc, uint32(len(utf16)))
+		desc = binary.BigEndian.AppendUint32(desc, 28) // String offset
+		return append(desc, utf16...)
+	}
+
+	// textDescriptionType with empty Unicode and ScriptCode parts
+	desc := []byte("desc\x00\x00\x00\x00")
+	desc = binary.BigEndian.AppendUint32(desc, uint32(len(text)+1))
+	desc = append(desc, text...)
+	desc = append(desc, 0)
+	return append(desc, make([]byte, 4+4+2+1+67)...)
+}
+
+// descriptionText extracts the ASCII text of a textDescriptionType or the
+// first record of a multiLocalizedUnicodeType tag. Non-ASCII characters are
+// dropped.
+func descriptionText(desc []byte) string {
+	if len(desc) < 12 {
+		return ""
+	}
+
+	var raw []byte
+	switch string(desc[0:4]) {
+	case "desc":
+		n := int(binary.BigEndian.Uint32(desc[8:12]))
+		if n > len(desc)-12 {
+			return ""
+		}
+		raw = bytes.TrimRight(desc[12:12+n], "\x00")
+	case "mluc":
+		if len(desc) < 28 || binary.BigEndian.Uint32(desc[8:12]) == 0 {
+			return ""
+		}
+		n := int(binary.BigEndian.Uint32(desc[20:24]))
+		offset := int(binary.BigEndian.Uint32(desc[24:28]))
+		if offset > len(desc) || n > len(desc)-offset {
+			return ""
+		}
+		for i := offset; i+1 < offset+n; i += 2 {
+			if desc[i] == 0 {
+				raw = append(raw, desc[i+1])
+			}
+		}
+	}
+
+	text := make([]byte, 0, len(raw))
+	for _, c := range raw {
+		if c >= 0x20 && c < 0x7F {
+			text = append(text, c)
+		}
+	}
+	return string(text)
+}
+
+// compressProfile builds iCCP chunk data with maximum zlib compression
+func compressProfile(name string, profile []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(name)
+	buf.Write([]byte{0, 0}) // Separator and compression method
+
+	zw, err := zlib.NewWriterLevel(&buf, zlib.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(profile); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
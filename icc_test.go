@@ -0,0 +1,318 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"encoding/binary"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// loadTestProfile returns the Display P3 profile shipped with the data creator
+func loadTestProfile(t *testing.T) []byte {
+	t.Helper()
+
+	profile, err := os.ReadFile(filepath.Join("datacreator", "DisplayP3-v2-micro.icc"))
+	if err != nil {
+		t.Fatalf("Failed to read ICC profile: %v", err)
+	}
+	return profile
+}
+
+// srgbTestProfile turns the Display P3 profile into an sRGB one; both use
+// the sRGB tone curve and differ only in their colorants
+func srgbTestProfile(t *testing.T, intent uint32) []byte {
+	t.Helper()
+
+	profile := bytes.Clone(loadTestProfile(t))
+	tags := iccTags(profile)
+	for sig, xyz := range srgbColorants {
+		tag := tags[sig]
+		for i, v := range xyz {
+			binary.BigEndian.PutUint32(tag[8+4*i:], uint32(int32(v*65536)))
+		}
+	}
+	binary.BigEndian.PutUint32(profile[64:68], intent)
+	return profile
+}
+
+func iccpData(t *testing.T, name string, profile []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	buf.WriteString(name)
+	buf.Write([]byte{0, 0})
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(profile); err != nil {
+		t.Fatalf("Failed to compress profile: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to compress profile: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func buildICCPNG(t *testing.T, extra ...[]byte) []byte {
+	t.Helper()
+
+	encoded := encodedChunks(t, image.NewRGBA(image.Rect(0, 0, 4, 4)))
+	chunks := [][]byte{makeChunk("IHDR", encoded["IHDR"])}
+	chunks = append(chunks, extra...)
+	chunks = append(chunks, makeChunk("IDAT", encoded["IDAT"]), makeChunk("IEND", nil))
+	return buildPNG(chunks...)
+}
+
+func TestIsSRGBProfile(t *testing.T) {
+	if isSRGBProfile(loadTestProfile(t)) {
+		t.Error("Display P3 profile detected as sRGB")
+	}
+	if !isSRGBProfile(srgbTestProfile(t, 0)) {
+		t.Error("sRGB profile not detected")
+	}
+
+	// A gamma 2.2 curve is close to, but not, sRGB
+	profile := srgbTestProfile(t, 0)
+	gamma := []byte("curv\x00\x00\x00\x00\x00\x00\x00\x01\x02\x33")
+	tags := iccTags(profile)
+	copy(tags["rTRC"], gamma)
+	if isSRGBProfile(profile) {
+		t.Error("Gamma 2.2 profile detected as sRGB")
+	}
+
+	if isSRGBProfile([]byte("not a profile")) {
+		t.Error("Garbage detected as sRGB")
+	}
+}
+
+func TestReplaceSRGBProfile(t *testing.T) {
+	for intent := uint32(0); intent < 4; intent++ {
+		iccp := makeChunk("iCCP", iccpData(t, "sRGB IEC61966-2.1", srgbTestProfile(t, intent)))
+		data := buildICCPNG(t, iccp)
+
+		cleaned, result, err := Strip(data)
+		if err != nil {
+			t.Fatalf("Failed to process PNG: %v", err)
+		}
+
+		if hasChunk(cleaned, "iCCP") || !hasChunk(cleaned, "sRGB") {
+			t.Fatalf("Expected iCCP to be replaced by sRGB, got %v", chunkTypes(cleaned))
+		}
+		srgb := makeChunk("sRGB", []byte{byte(intent)})
+		if !bytes.Contains(cleaned, srgb) {
+			t.Errorf("Expected sRGB chunk with intent %d", intent)
+		}
+
+		if result.Optimized.ColorProfile != len(iccp)-len(srgb) || result.Total != len(data)-len(cleaned) {
+			t.Errorf("Unexpected savings: profile=%d total=%d", result.Optimized.ColorProfile, result.Total)
+		}
+		if result.Chunks[1].Type != "iCCP" || result.Chunks[1].Kept || result.Chunks[1].Reason != ReasonSRGB {
+			t.Errorf("Unexpected chunk log entry %+v", result.Chunks[1])
+		}
+		if err := verifyImageIntegrity(data, cleaned); err != nil {
+			t.Errorf("Image integrity check failed: %v", err)
+		}
+	}
+}
+
+func TestReplaceSRGBProfileKeepsOthers(t *testing.T) {
+	srgb := makeChunk("iCCP", iccpData(t, "sRGB", srgbTestProfile(t, 0)))
+
+	tests := []struct {
+		name string
+		data []byte
+		opts Options
+	}{
+		{
+			"Display P3", buildICCPNG(t, makeChunk("iCCP", iccpData(t, "Display P3", loadTestProfile(t)))),
+			Options{KeepColor: true, ReplaceSRGBProfile: true},
+		},
+		{"Corrupt stream", buildICCPNG(t, makeChunk("iCCP", []byte("name\x00\x00garbage"))), DefaultOptions()},
+		{"Disabled", buildICCPNG(t, srgb), Options{KeepColor: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cleaned, result, err := StripWithOptions(tt.data, tt.opts)
+			if err != nil {
+				t.Fatalf("Failed to process PNG: %v", err)
+			}
+			if !bytes.Equal(cleaned, tt.data) || result.Total != 0 {
+				t.Error("Expected the profile to be kept verbatim")
+			}
+		})
+	}
+}
+
+func TestReplaceSRGBProfileWithExistingSRGB(t *testing.T) {
+	iccp := makeChunk("iCCP", iccpData(t, "sRGB", srgbTestProfile(t, 0)))
+	srgb := makeChunk("sRGB", []byte{1})
+
+	for name, data := range map[string][]byte{
+		"sRGB first": buildICCPNG(t, srgb, iccp),
+		"iCCP first": buildICCPNG(t, iccp, srgb),
+	} {
+		t.Run(name, func(t *testing.T) {
+			cleaned, _, err := Strip(data)
+			if err != nil {
+				t.Fatalf("Failed to process PNG: %v", err)
+			}
+
+			count := 0
+			for _, chunkType := range chunkTypes(cleaned) {
+				if chunkType == "sRGB" {
+					count++
+				}
+			}
+			if count != 1 || hasChunk(cleaned, "iCCP") {
+				t.Errorf("Expected a single sRGB chunk, got %v", chunkTypes(cleaned))
+			}
+		})
+	}
+}
+
+// bloatedProfile adds the tags commonly found in vendor profiles to the
+// Display P3 profile: multi-language descriptions, copyright text and a
+// private tag
+func bloatedProfile(t *testing.T, version byte) []byte {
+	t.Helper()
+
+	profile := loadTestProfile(t)
+	tags := iccTags(profile)
+	count := int(binary.BigEndian.Uint32(profile[128:132]))
+
+	var sigs []string
+	for i := 0; i < count; i++ {
+		sigs = append(sigs, string(profile[132+12*i:136+12*i]))
+	}
+	tags["cprt"] = append([]byte("text\x00\x00\x00\x00"), bytes.Repeat([]byte("Copyright Example Corporation. "), 8)...)
+	tags["dscm"] = append([]byte("mluc\x00\x00\x00\x00"), bytes.Repeat([]byte{0, 'D', 0, 'i', 0, 's', 0, 'p'}, 64)...)
+	tags["ZXYZ"] = bytes.Repeat([]byte{0x5A}, 512)
+	sigs = append(sigs, "cprt", "dscm", "ZXYZ")
+
+	out := make([]byte, 132+12*len(sigs))
+	copy(out, profile[:128])
+	out[8] = version
+	binary.BigEndian.PutUint32(out[128:132], uint32(len(sigs)))
+	for i, sig := range sigs {
+		entry := out[132+12*i:]
+		copy(entry[0:4], sig)
+		binary.BigEndian.PutUint32(entry[4:8], uint32(len(out)))
+		binary.BigEndian.PutUint32(entry[8:12], uint32(len(tags[sig])))
+		out = append(out, tags[sig]...)
+		for len(out)%4 != 0 {
+			out = append(out, 0)
+		}
+	}
+	binary.BigEndian.PutUint32(out[0:4], uint32(len(out)))
+	return out
+}
+
+// decodeICCP returns the profile embedded in the iCCP chunk of a PNG
+func decodeICCP(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	for offset := 8; offset+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		if string(data[offset+4:offset+8]) == "iCCP" {
+			iccp, err := parseICCP(data[offset+8 : offset+8+length])
+			if err != nil {
+				t.Fatalf("Failed to decode iCCP: %v", err)
+			}
+			return iccp.profile
+		}
+		offset += 12 + length
+	}
+	t.Fatal("No iCCP chunk found")
+	return nil
+}
+
+func TestMinimizeProfile(t *testing.T) {
+	original := bloatedProfile(t, 2)
+	data := buildICCPNG(t, makeChunk("iCCP", iccpData(t, "Display P3", original)))
+
+	cleaned, result, err := Strip(data)
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+	if len(cleaned) >= len(data) {
+		t.Fatalf("Expected a smaller file, got %d bytes from %d", len(cleaned), len(data))
+	}
+	if result.Optimized.ProfileMinimization != len(data)-len(cleaned) || result.Total != len(data)-len(cleaned) {
+		t.Errorf("Unexpected savings: profile=%d total=%d", result.Optimized.ProfileMinimization, result.Total)
+	}
+
+	profile := decodeICCP(t, cleaned)
+	if int(binary.BigEndian.Uint32(profile[0:4])) != len(profile) {
+		t.Error("Profile size field does not match the profile length")
+	}
+
+	// Every tag needed for color transforms is kept byte for byte
+	before, after := iccTags(original), iccTags(profile)
+	for sig := range colorTransformTags {
+		if !bytes.Equal(before[sig], after[sig]) {
+			t.Errorf("Tag %s changed", sig)
+		}
+	}
+	for _, sig := range []string{"cprt", "dscm", "ZXYZ"} {
+		if after[sig] != nil {
+			t.Errorf("Expected tag %s to be removed", sig)
+		}
+	}
+	if desc := descriptionText(after["desc"]); desc != descriptionText(before["desc"]) {
+		t.Errorf("Description changed from %q to %q", descriptionText(before["desc"]), desc)
+	}
+
+	// Minimizing again finds nothing more to remove
+	again, result, err := Strip(cleaned)
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+	if !bytes.Equal(again, cleaned) || result.Total != 0 {
+		t.Error("Expected a minimized profile to be kept as is")
+	}
+}
+
+func TestMinimizeProfileVersion4(t *testing.T) {
+	original := bloatedProfile(t, 4)
+	copy(original[84:100], bytes.Repeat([]byte{0xAA}, 16))
+	data := buildICCPNG(t, makeChunk("iCCP", iccpData(t, "Display P3", original)))
+
+	cleaned, _, err := Strip(data)
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+
+	profile := decodeICCP(t, cleaned)
+	desc := iccTags(profile)["desc"]
+	if string(desc[0:4]) != "mluc" {
+		t.Errorf("Expected a multiLocalizedUnicodeType description, got %q", desc[0:4])
+	}
+	if text := descriptionText(desc); text != "uP3" {
+		t.Errorf("Expected description %q, got %q", "uP3", text)
+	}
+
+	// The profile ID is the MD5 of the profile with flags, intent and ID zeroed
+	tmp := bytes.Clone(profile)
+	clear(tmp[44:48])
+	clear(tmp[64:68])
+	clear(tmp[84:100])
+	if sum := md5.Sum(tmp); !bytes.Equal(profile[84:100], sum[:]) {
+		t.Error("Profile ID was not recalculated")
+	}
+}
+
+func TestMinimizeProfileDisabled(t *testing.T) {
+	data := buildICCPNG(t, makeChunk("iCCP", iccpData(t, "Display P3", bloatedProfile(t, 2))))
+
+	cleaned, err := io.ReadAll(NewReaderWithOptions(bytes.NewReader(data), Options{KeepColor: true}))
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+	if !bytes.Equal(cleaned, data) {
+		t.Error("Expected the profile to be kept verbatim")
+	}
+}
@@ -0,0 +1,71 @@
+package pngmetawebstrip
+
+// rewritesIDAT reports whether opts merges or splits IDAT chunks
+func rewritesIDAT(opts Options) bool {
+	return opts.MergeIDAT || opts.SplitIDAT > 0
+}
+
+// bufferIDAT collects the data of an IDAT chunk for Options.MergeIDAT and
+// SplitIDAT. With SplitIDAT, chunks are written as soon as they are full;
+// the rest is written by endIDAT when the run of IDAT chunks ends.
+func (s *stripper) bufferIDAT(chunk []byte) error {
+	if len(chunk) == 12 {
+		info := s.currentChunk()
+		info.Kept = false
+		info.Reason = ReasonEmpty
+	}
+	s.idatRun = true
+	s.result.Optimized.IDATChunks += len(chunk)
+	s.result.Total += len(chunk)
+	s.idat = append(s.idat, chunk[8:len(chunk)-4]...)
+
+	size := s.policy.opts.SplitIDAT
+	for size > 0 && len(s.idat) > size {
+		if err := s.writeIDAT(s.idat[:size]); err != nil {
+			return err
+		}
+		s.idat = s.idat[size:]
+	}
+	return nil
+}
+
+// endIDAT writes the IDAT data buffered since the end of the last chunk,
+// keeping one empty IDAT chunk when all of them were empty
+func (s *stripper) endIDAT() error {
+	if !s.idatRun {
+		return nil
+	}
+	s.idatRun = false
+
+	var err error
+	if len(s.idat) > 0 || s.idatWritten == 0 {
+		err = s.writeIDAT(s.idat)
+	}
+	s.idat, s.idatWritten = nil, 0
+	return err
+}
+
+// writeIDAT writes an IDAT chunk holding data
+func (s *stripper) writeIDAT(data []byte) error {
+	chunk := buildChunk("IDAT", data)
+	s.result.Optimized.IDATChunks -= len(chunk)
+	s.result.Total -= len(chunk)
+	s.idatWritten++
+	return s.write(chunk)
+}
+
+// splitIDAT returns IDAT chunks holding data, split in chunks of at most
+// size bytes when size is positive
+func splitIDAT(data []byte, size int) [][]byte {
+	if size <= 0 || len(data) <= size {
+		return [][]byte{buildChunk("IDAT", data)}
+	}
+
+	var chunks [][]byte
+	for len(data) > 0 {
+		n := min(size, len(data))
+		chunks = append(chunks, buildChunk("IDAT", data[:n]))
+		data = data[n:]
+	}
+	return chunks
+}
@@ -0,0 +1,140 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"image/png"
+	"io"
+	"slices"
+	"testing"
+)
+
+// fragmentedPNG builds an image whose data is spread over IDAT chunks of
+// 10 bytes, with empty IDAT chunks at the start and in the middle
+func fragmentedPNG(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+
+	encoded := encodedChunks(t, testImages()["nrgba"])
+	idat := encoded["IDAT"]
+	chunks := [][]byte{makeChunk("IHDR", encoded["IHDR"]), makeChunk("IDAT", nil)}
+	for i := 0; i < len(idat); i += 10 {
+		chunks = append(chunks, makeChunk("IDAT", idat[i:min(i+10, len(idat))]))
+		if i == 20 {
+			chunks = append(chunks, makeChunk("IDAT", nil))
+		}
+	}
+	chunks = append(chunks, makeChunk("tEXt", []byte("Comment\x00hello")), makeChunk("IEND", nil))
+	return buildPNG(chunks...), idat
+}
+
+// idatChunks returns the data of each IDAT chunk of a PNG
+func idatChunks(data []byte) [][]byte {
+	var chunks [][]byte
+	for offset := 8; offset+8 <= len(data); offset += chunkSize(data[offset:]) {
+		if string(data[offset+4:offset+8]) == "IDAT" {
+			chunks = append(chunks, data[offset+8:offset+chunkSize(data[offset:])-4])
+		}
+	}
+	return chunks
+}
+
+func TestMergeIDAT(t *testing.T) {
+	data, idat := fragmentedPNG(t)
+	input := len(idatChunks(data))
+
+	opts := DefaultOptions()
+	opts.MergeIDAT = true
+	cleaned, result, err := StripWithOptions(data, opts)
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+
+	chunks := idatChunks(cleaned)
+	if len(chunks) != 1 || !bytes.Equal(chunks[0], idat) {
+		t.Fatalf("Expected a single IDAT chunk with the original data, got %d chunks", len(chunks))
+	}
+	if _, err := png.Decode(bytes.NewReader(cleaned)); err != nil {
+		t.Errorf("Failed to decode merged PNG: %v", err)
+	}
+
+	if saved := 12 * (input - 1); result.Optimized.IDATChunks != saved {
+		t.Errorf("Expected %d bytes saved on IDAT chunks, got %d", saved, result.Optimized.IDATChunks)
+	}
+	if result.Total != len(data)-len(cleaned) {
+		t.Errorf("Total is %d, expected %d", result.Total, len(data)-len(cleaned))
+	}
+
+	empty := 0
+	for _, info := range result.Chunks {
+		if info.Type == "IDAT" && !info.Kept {
+			empty++
+			if info.Reason != ReasonEmpty || info.Length != 0 {
+				t.Errorf("Unexpected entry for dropped IDAT %+v", info)
+			}
+		}
+	}
+	if empty != 2 {
+		t.Errorf("Expected 2 empty IDAT chunks dropped, got %d", empty)
+	}
+}
+
+func TestSplitIDAT(t *testing.T) {
+	data, idat := fragmentedPNG(t)
+
+	for _, size := range []int{7, 32, 1 << 20} {
+		opts := DefaultOptions()
+		opts.SplitIDAT = size
+		cleaned, result, err := StripWithOptions(data, opts)
+		if err != nil {
+			t.Fatalf("Failed to process PNG: %v", err)
+		}
+
+		chunks := idatChunks(cleaned)
+		if expected := (len(idat) + size - 1) / size; len(chunks) != expected {
+			t.Errorf("Size %d: expected %d IDAT chunks, got %d", size, expected, len(chunks))
+		}
+		for _, chunk := range chunks[:len(chunks)-1] {
+			if len(chunk) != size {
+				t.Errorf("Size %d: got an IDAT chunk of %d bytes", size, len(chunk))
+			}
+		}
+		if !bytes.Equal(bytes.Join(chunks, nil), idat) {
+			t.Errorf("Size %d: image data changed", size)
+		}
+		if result.Total != len(data)-len(cleaned) {
+			t.Errorf("Size %d: Total is %d, expected %d", size, result.Total, len(data)-len(cleaned))
+		}
+
+		streamed, err := io.ReadAll(NewReaderWithOptions(bytes.NewReader(data), opts))
+		if err != nil {
+			t.Fatalf("Failed to read stripped PNG: %v", err)
+		}
+		if !bytes.Equal(streamed, cleaned) {
+			t.Errorf("Size %d: Reader output differs from StripWithOptions output", size)
+		}
+	}
+}
+
+func TestSplitIDATWithRecompress(t *testing.T) {
+	data, _ := fragmentedPNG(t)
+
+	opts := DefaultOptions()
+	opts.Recompress = true
+	opts.SplitIDAT = 16
+	cleaned, result, err := StripWithOptions(data, opts)
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+
+	chunks := idatChunks(cleaned)
+	if len(chunks) < 2 || slices.ContainsFunc(chunks, func(c []byte) bool { return len(c) > 16 }) {
+		t.Errorf("Recompressed data not split into 16 byte chunks: %d chunks", len(chunks))
+	}
+	decoded, err := png.Decode(bytes.NewReader(cleaned))
+	if err != nil {
+		t.Fatalf("Failed to decode PNG: %v", err)
+	}
+	assertSamePixels(t, testImages()["nrgba"], decoded)
+	if result.Total != len(data)-len(cleaned) {
+		t.Errorf("Total is %d, expected %d", result.Total, len(data)-len(cleaned))
+	}
+}
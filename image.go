@@ -0,0 +1,237 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// holdsImage reports whether opts rewrites the image data, which requires
+// every chunk up to IEND to be held
+func holdsImage(opts Options) bool {
+	return opts.ApplyOrientation || opts.Recompress || reducesColor(opts) ||
+		opts.CleanTransparent != TransparentKeep || opts.Interlace != InterlaceKeep
+}
+
+// imageEdit describes the changes rewriteImage makes to the held image
+type imageEdit struct {
+	orientation int             // EXIF orientation to bake into the pixels, 0 for none
+	fill        TransparentFill // Color given to fully transparent pixels
+	interlace   Interlacing     // Requested interlace method
+
+	idat  []byte // Concatenated image data
+	first int    // Index of the first held IDAT chunk, -1 if there is none
+	size  int    // Total size of the held chunks
+
+	cleaned int  // Transparent pixels whose color was changed
+	changed bool // Pixels or interlacing changed, so the image is rewritten even if it grows
+}
+
+// rewriteImage decodes the held image data, applies the requested pixel
+// changes and color reduction and replaces IHDR and IDAT with the
+// re-encoded image. The held chunks are returned unchanged when there is
+// nothing to do, the image cannot be decoded, or only size reductions were
+// requested and the image does not get smaller.
+func (s *stripper) rewriteImage(held []heldChunk) []heldChunk {
+	opts := s.policy.opts
+	e := s.scanImage(held)
+	if e.first < 0 || !e.requested(opts) {
+		return held
+	}
+
+	offset := s.result.Chunks[held[e.first].info].Offset
+	r, err := s.transformImage(e)
+	if err != nil {
+		s.warn("IDAT", offset, "image data not rewritten: %v", err)
+		return held
+	}
+
+	converted, reduced := held, r
+	if reducesColor(opts) {
+		converted, reduced = s.reduceColors(held, r, offset)
+	}
+	if reduced == r && !e.changed && !opts.Recompress {
+		return held
+	}
+
+	encoded, err := encodeImage(reduced, opts)
+	if err != nil {
+		s.warn("IDAT", offset, "image data not rewritten: %v", err)
+		return held
+	}
+	rewritten := spliceImage(converted, reduced, encoded, e.orientation, opts.SplitIDAT)
+
+	saved := e.size
+	for _, c := range rewritten {
+		saved -= len(c.data)
+	}
+
+	// Recompression and color reduction are only worth it when the image
+	// gets smaller
+	if !e.changed && saved <= 0 {
+		return held
+	}
+
+	s.result.Optimized.ImageData += saved
+	s.result.Total += saved
+	if e.orientation > 1 {
+		s.result.Orientation = e.orientation
+		s.result.PixelTransform = true
+	}
+	s.result.TransparentPixels = e.cleaned
+	return rewritten
+}
+
+// scanImage collects the image data of the held chunks and the pixel
+// changes that apply to it. Animated images only get their colors reduced
+// or their data recompressed.
+func (s *stripper) scanImage(held []heldChunk) *imageEdit {
+	opts := s.policy.opts
+	e := &imageEdit{fill: opts.CleanTransparent, interlace: opts.Interlace, first: -1}
+	if opts.ApplyOrientation && s.orientation > 1 {
+		e.orientation = s.orientation
+	}
+
+	for i, c := range held {
+		e.size += len(c.data)
+		switch c.chunkType() {
+		case "acTL":
+			s.keepAnimation(e, s.result.Chunks[c.info].Offset)
+		case "IDAT":
+			if e.first < 0 {
+				e.first = i
+			}
+			e.idat = append(e.idat, c.data[8:len(c.data)-4]...)
+		}
+	}
+	return e
+}
+
+// keepAnimation cancels the pixel changes of e, which the frames of an
+// animated image would not get, warning about each one
+func (s *stripper) keepAnimation(e *imageEdit, offset int) {
+	if e.orientation > 1 {
+		s.warn("acTL", offset, "orientation not applied to animated image")
+		e.orientation = 0
+	}
+	if e.fill != TransparentKeep {
+		s.warn("acTL", offset, "transparent pixels not cleaned in animated image")
+		e.fill = TransparentKeep
+	}
+	if e.interlace != InterlaceKeep {
+		s.warn("acTL", offset, "interlacing not changed in animated image")
+		e.interlace = InterlaceKeep
+	}
+}
+
+// requested reports whether opts asks for anything to be done to the image
+func (e *imageEdit) requested(opts Options) bool {
+	return e.orientation > 1 || e.fill != TransparentKeep || e.interlace != InterlaceKeep ||
+		opts.Recompress || reducesColor(opts)
+}
+
+// transformImage decodes the image data and applies the orientation,
+// transparent pixel cleaning and interlacing of e
+func (s *stripper) transformImage(e *imageEdit) (*raster, error) {
+	r, err := decodeRaster(s.result.Header, e.idat)
+	if err != nil {
+		return nil, err
+	}
+	if e.orientation > 1 {
+		r = r.orient(e.orientation)
+	}
+	e.cleaned = r.cleanTransparent(e.fill)
+
+	interlaced := r.interlaced
+	if e.interlace != InterlaceKeep {
+		r.interlaced = outputInterlaced(s.policy.opts, r.width, r.height, r.interlaced)
+	}
+
+	// Pixel and interlacing changes are applied even when the image grows
+	e.changed = e.orientation > 1 || e.cleaned > 0 || r.interlaced != interlaced
+	return r, nil
+}
+
+// encodeImage compresses r, trying every filter strategy with
+// Options.Recompress, and checks that the result decodes to the same pixels
+func encodeImage(r *raster, opts Options) ([]byte, error) {
+	var encoded []byte
+	var err error
+	if opts.Recompress {
+		encoded, err = r.compress(opts.OptimalDeflateLimit)
+	} else {
+		encoded, err = r.encode(adaptiveFilter)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyRaster(r, encoded); err != nil {
+		return nil, err
+	}
+	return encoded, nil
+}
+
+// spliceImage replaces IHDR and the IDAT chunks of held with those of the
+// encoded raster, and adapts pHYs and eXIf to the applied orientation
+func spliceImage(held []heldChunk, r *raster, encoded []byte, orientation, splitSize int) []heldChunk {
+	rewritten := make([]heldChunk, 0, len(held))
+	wroteIDAT := false
+	for _, c := range held {
+		switch c.chunkType() {
+		case "IHDR":
+			c.data = rewrittenHeader(c.data, r)
+		case "IDAT":
+			if !wroteIDAT {
+				for _, chunk := range splitIDAT(encoded, splitSize) {
+					rewritten = append(rewritten, heldChunk{info: c.info, data: chunk})
+				}
+				wroteIDAT = true
+			}
+			continue
+		case "pHYs":
+			if orientation >= 5 {
+				c.data = transposedPhysical(c.data)
+			}
+		case "eXIf":
+			if orientation > 1 {
+				c.data = buildChunk("eXIf", resetOrientation(c.data[8:len(c.data)-4]))
+			}
+		}
+		rewritten = append(rewritten, c)
+	}
+	return rewritten
+}
+
+// verifyRaster checks that encoded image data decodes to the pixels of r
+func verifyRaster(r *raster, encoded []byte) error {
+	decoded, zr, err := readRaster(r.header(), encoded)
+	if err != nil {
+		return err
+	}
+	// The stream must end after the last row, with a valid checksum
+	if n, err := io.Copy(io.Discard, zr); n > 0 || err != nil {
+		return fmt.Errorf("%w: re-encoded image data does not end after the last row", ErrInvalidChunk)
+	}
+	if !bytes.Equal(decoded.pix, r.pix) {
+		return fmt.Errorf("%w after re-encoding", ErrPixelMismatch)
+	}
+	return nil
+}
+
+// rewrittenHeader returns an IHDR chunk with the dimensions, color type,
+// bit depth and interlacing of r
+func rewrittenHeader(chunk []byte, r *raster) []byte {
+	data := bytes.Clone(chunk[8 : len(chunk)-4])
+	if len(data) != 13 {
+		return chunk
+	}
+	binary.BigEndian.PutUint32(data[0:4], uint32(r.width))
+	binary.BigEndian.PutUint32(data[4:8], uint32(r.height))
+	data[8], data[9] = byte(r.bitDepth), byte(r.colorType)
+	data[12] = 0
+	if r.interlaced {
+		data[12] = 1
+	}
+	return buildChunk("IHDR", data)
+}
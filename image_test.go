@@ -0,0 +1,192 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// storedIDAT re-deflates image data without compression, as a poorly
+// optimizing encoder would, and splits it into chunks of up to 64 bytes
+func storedIDAT(t *testing.T, idat []byte) [][]byte {
+	t.Helper()
+
+	zr, err := zlib.NewReader(bytes.NewReader(idat))
+	if err != nil {
+		t.Fatalf("Failed to inflate image data: %v", err)
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("Failed to inflate image data: %v", err)
+	}
+
+	var buf bytes.Buffer
+	zw, _ := zlib.NewWriterLevel(&buf, zlib.NoCompression)
+	zw.Write(raw)
+	zw.Close()
+
+	var chunks [][]byte
+	for stored := buf.Bytes(); len(stored) > 0; {
+		n := min(64, len(stored))
+		chunks = append(chunks, makeChunk("IDAT", stored[:n]))
+		stored = stored[n:]
+	}
+	return chunks
+}
+
+func TestRecompress(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Recompress = true
+
+	for name, img := range testImages() {
+		for _, interlaced := range []bool{false, true} {
+			encoded := encodedChunks(t, img)
+			idat := encoded["IDAT"]
+			if interlaced {
+				h := parseImageHeader(encoded["IHDR"])
+				r, err := decodeRaster(h, idat)
+				if err != nil {
+					t.Fatalf("Failed to decode raster: %v", err)
+				}
+				idat = interlacedData(t, r)
+				encoded["IHDR"][12] = 1
+			}
+
+			chunks := [][]byte{makeChunk("IHDR", encoded["IHDR"])}
+			if encoded["PLTE"] != nil {
+				chunks = append(chunks, makeChunk("PLTE", encoded["PLTE"]))
+			}
+			chunks = append(chunks, storedIDAT(t, idat)...)
+			data := buildPNG(append(chunks, makeChunk("IEND", nil))...)
+
+			cleaned, result, err := StripWithOptions(data, opts)
+			if err != nil {
+				t.Fatalf("%s: Failed to process PNG: %v", name, err)
+			}
+			types := chunkTypes(cleaned)
+			if !slices.Equal(types, []string{"IHDR", "IDAT", "IEND"}) && !slices.Equal(types, []string{"IHDR", "PLTE", "IDAT", "IEND"}) {
+				t.Errorf("%s: Expected a single IDAT chunk, got %v", name, types)
+			}
+			if ihdr := encodedChunksFromPNG(cleaned)["IHDR"]; !bytes.Equal(ihdr, encoded["IHDR"]) {
+				t.Errorf("%s: IHDR changed from %v to %v", name, encoded["IHDR"], ihdr)
+			}
+
+			decoded, err := png.Decode(bytes.NewReader(cleaned))
+			if err != nil {
+				t.Fatalf("%s: Failed to decode recompressed PNG: %v", name, err)
+			}
+			assertSamePixels(t, img, decoded)
+
+			if result.Optimized.ImageData <= 0 {
+				t.Errorf("%s: Expected image data savings, got %d", name, result.Optimized.ImageData)
+			}
+			if result.Total != len(data)-len(cleaned) {
+				t.Errorf("%s: Total is %d, expected %d", name, result.Total, len(data)-len(cleaned))
+			}
+			if result.PixelTransform {
+				t.Errorf("%s: Recompression reported as a pixel transform", name)
+			}
+		}
+	}
+}
+
+func TestRecompressUnchanged(t *testing.T) {
+	// Image data that recompression cannot improve is kept as is
+	encoded := encodedChunks(t, testImages()["nrgba"])
+	r, err := decodeRaster(parseImageHeader(encoded["IHDR"]), encoded["IDAT"])
+	if err != nil {
+		t.Fatalf("Failed to decode raster: %v", err)
+	}
+	idat, err := r.compress(0)
+	if err != nil {
+		t.Fatalf("Failed to encode raster: %v", err)
+	}
+	data := buildPNG(makeChunk("IHDR", encoded["IHDR"]), makeChunk("IDAT", idat), makeChunk("IEND", nil))
+
+	opts := DefaultOptions()
+	opts.Recompress = true
+	cleaned, result, err := StripWithOptions(data, opts)
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+	if !bytes.Equal(cleaned, data) {
+		t.Error("Image data rewritten without a saving")
+	}
+	if result.Optimized.ImageData != 0 || result.Total != 0 {
+		t.Errorf("Expected no savings, got %d image data and %d total", result.Optimized.ImageData, result.Total)
+	}
+}
+
+func TestRecompressOptimal(t *testing.T) {
+	// A noisy gradient, where optimal parsing beats compress/flate
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			v := uint8(x/7*(y/5) + rng.Intn(4))
+			img.SetNRGBA(x, y, color.NRGBA{v, uint8(x), uint8(y / 3), 255})
+		}
+	}
+	encoded := encodedChunks(t, img)
+	data := buildPNG(makeChunk("IHDR", encoded["IHDR"]), makeChunk("IDAT", encoded["IDAT"]), makeChunk("IEND", nil))
+
+	opts := DefaultOptions()
+	opts.Recompress = true
+	_, standard, err := StripWithOptions(data, opts)
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+
+	opts.OptimalDeflateLimit = 1 << 20
+	cleaned, result, err := StripWithOptions(data, opts)
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+	if result.Optimized.ImageData <= standard.Optimized.ImageData {
+		t.Errorf("Expected optimal deflate to save more than %d bytes, got %d", standard.Optimized.ImageData, result.Optimized.ImageData)
+	}
+	decoded, err := png.Decode(bytes.NewReader(cleaned))
+	if err != nil {
+		t.Fatalf("Failed to decode recompressed PNG: %v", err)
+	}
+	assertSamePixels(t, img, decoded)
+}
+
+func TestVerifyRasterStreamEnd(t *testing.T) {
+	encoded := encodedChunks(t, testImages()["nrgba"])
+	r, err := decodeRaster(parseImageHeader(encoded["IHDR"]), encoded["IDAT"])
+	if err != nil {
+		t.Fatalf("Failed to decode raster: %v", err)
+	}
+
+	filtered := r.filter(adaptiveFilter)
+	extra, err := deflate(append(filtered, 0, 0, 0))
+	if err != nil {
+		t.Fatalf("Failed to deflate: %v", err)
+	}
+	checksum := zlibOptimal(filtered)
+	checksum[len(checksum)-1] ^= 1
+
+	tests := []struct {
+		name string
+		idat []byte
+	}{
+		{"Data past the last row", extra},
+		{"Bad checksum", checksum},
+		{"Truncated stream", zlibOptimal(filtered)[:len(checksum)-4]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyRaster(r, tt.idat); !errors.Is(err, ErrInvalidChunk) {
+				t.Errorf("Expected ErrInvalidChunk, got %v", err)
+			}
+		})
+	}
+}
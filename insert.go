@@ -0,0 +1,157 @@
+package pngmetawebstrip
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+// Insertion is a chunk added to the output by Options.Insert. Use the
+// Insert functions to build one with valid contents.
+type Insertion struct {
+	Type string // Chunk type, e.g. "tEXt"
+	Data []byte // Chunk data, without length, type and CRC
+}
+
+// Rendering intents of the sRGB chunk
+const (
+	IntentPerceptual = 0
+	IntentRelative   = 1 // Relative colorimetric
+	IntentSaturation = 2
+	IntentAbsolute   = 3 // Absolute colorimetric
+)
+
+// InsertText returns a text chunk with the given keyword: tEXt when the text
+// can be encoded as Latin-1, iTXt otherwise
+func InsertText(keyword, text string) (Insertion, error) {
+	latinKeyword, ok := keywordLatin1(keyword)
+	if !ok {
+		return Insertion{}, fmt.Errorf("invalid text chunk keyword %q", keyword)
+	}
+	if !utf8.ValidString(text) {
+		return Insertion{}, fmt.Errorf("invalid text for keyword %q: not valid UTF-8", keyword)
+	}
+
+	if latinText, ok := latin1(text); ok {
+		data := append(append(latinKeyword, 0), latinText...)
+		return Insertion{Type: "tEXt", Data: data}, nil
+	}
+
+	// Uncompressed, without language tag or translated keyword
+	data := append(latinKeyword, 0, 0, 0, 0, 0)
+	return Insertion{Type: "iTXt", Data: append(data, text...)}, nil
+}
+
+// latin1 encodes s as ISO 8859-1 without control characters other than
+// newline, reporting whether that is possible
+func latin1(s string) ([]byte, bool) {
+	out := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xFF || r < 0x20 && r != '\n' || r >= 0x7F && r < 0xA0 {
+			return nil, false
+		}
+		out = append(out, byte(r))
+	}
+	return out, true
+}
+
+// keywordLatin1 encodes a keyword of a text chunk as ISO 8859-1, reporting
+// whether it is valid: 1 to 79 printable characters without leading or
+// trailing spaces
+func keywordLatin1(keyword string) ([]byte, bool) {
+	out := make([]byte, 0, len(keyword))
+	for _, r := range keyword {
+		if r < 0x20 || r > 0x7E && r < 0xA1 || r > 0xFF {
+			return nil, false
+		}
+		out = append(out, byte(r))
+	}
+	if len(out) == 0 || len(out) > 79 || out[0] == ' ' || out[len(out)-1] == ' ' {
+		return nil, false
+	}
+	return out, true
+}
+
+// InsertDPI returns a pHYs chunk declaring square pixels at the given
+// resolution in dots per inch, which must be positive and finite
+func InsertDPI(dpi float64) (Insertion, error) {
+	ppm := math.Round(dpi / 0.0254)
+	if !(ppm >= 1 && ppm <= math.MaxInt32) {
+		return Insertion{}, fmt.Errorf("invalid resolution %v dpi", dpi)
+	}
+
+	data := binary.BigEndian.AppendUint32(nil, uint32(ppm))
+	data = binary.BigEndian.AppendUint32(data, uint32(ppm))
+	return Insertion{Type: "pHYs", Data: append(data, 1)}, nil // Unit is the meter
+}
+
+// InsertSRGB returns an sRGB chunk with the given rendering intent, one of
+// the Intent constants
+func InsertSRGB(intent byte) (Insertion, error) {
+	if intent > IntentAbsolute {
+		return Insertion{}, fmt.Errorf("invalid sRGB rendering intent %d", intent)
+	}
+	return Insertion{Type: "sRGB", Data: []byte{intent}}, nil
+}
+
+// InsertICCProfile returns an iCCP chunk embedding the ICC profile, as read
+// from an .icc file, with maximum compression
+func InsertICCProfile(name string, profile []byte) (Insertion, error) {
+	if len(name) == 0 || len(name) > 79 {
+		return Insertion{}, fmt.Errorf("invalid profile name %q", name)
+	}
+	if iccTags(profile) == nil {
+		return Insertion{}, fmt.Errorf("%w: not an ICC profile", ErrInvalidChunk)
+	}
+
+	data, err := compressProfile(name, profile)
+	if err != nil {
+		return Insertion{}, err
+	}
+	return Insertion{Type: "iCCP", Data: data}, nil
+}
+
+// replacedByInsertion reports whether a kept chunk conflicts with one of
+// Options.Insert: text chunks with the same keyword, any sRGB or iCCP when
+// one of them is inserted, and other chunks of the same type
+func (p *policy) replacedByInsertion(chunkType string, data []byte) bool {
+	switch {
+	case len(p.opts.Insert) == 0:
+		return false
+	case textChunks[chunkType]:
+		return p.insertedText[textKeyword(data)]
+	case chunkType == "sRGB" || chunkType == "iCCP":
+		return p.inserted["sRGB"] || p.inserted["iCCP"]
+	default:
+		return p.inserted[chunkType]
+	}
+}
+
+// insert adds the chunks of Options.Insert to the held chunks, before the
+// first PLTE or IDAT where color and physical chunks must appear
+func (s *stripper) insert(held []heldChunk, next int) []heldChunk {
+	at := len(held)
+	for i, c := range held {
+		if chunkType := c.chunkType(); chunkType == "PLTE" || chunkType == "IDAT" {
+			at, next = i, s.result.Chunks[c.info].Offset
+			break
+		}
+	}
+
+	inserted := make([]heldChunk, 0, len(held)+len(s.policy.opts.Insert))
+	inserted = append(inserted, held[:at]...)
+	for _, ins := range s.policy.opts.Insert {
+		chunk := buildChunk(ins.Type, ins.Data)
+		s.result.Inserted = append(s.result.Inserted, ChunkInfo{
+			Type:   ins.Type,
+			Offset: next,
+			Length: len(ins.Data),
+			Kept:   true,
+			Reason: ReasonInserted,
+		})
+		s.result.Total -= len(chunk)
+		inserted = append(inserted, heldChunk{info: -1, data: chunk})
+	}
+	return append(inserted, held[at:]...)
+}
@@ -0,0 +1,162 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestInsertText(t *testing.T) {
+	text, err := InsertText("Copyright", "(c) 2024 Exämple")
+	if err != nil {
+		t.Fatalf("Failed to build text chunk: %v", err)
+	}
+	if text.Type != "tEXt" || !bytes.Equal(text.Data, []byte("Copyright\x00(c) 2024 Ex\xE4mple")) {
+		t.Errorf("Expected a Latin-1 tEXt chunk, got %s %q", text.Type, text.Data)
+	}
+
+	intl, err := InsertText("Title", "日本語")
+	if err != nil {
+		t.Fatalf("Failed to build text chunk: %v", err)
+	}
+	if intl.Type != "iTXt" || !bytes.Equal(intl.Data, []byte("Title\x00\x00\x00\x00\x00日本語")) {
+		t.Errorf("Expected an iTXt chunk, got %s %q", intl.Type, intl.Data)
+	}
+
+	keywords := []string{"", " Leading", "Trailing ", "日本", "Line\nbreak", "Tab\t", "Delete\x7F", "\u00A0Space", strings.Repeat("k", 80)}
+	for _, keyword := range keywords {
+		if _, err := InsertText(keyword, "text"); err == nil || !strings.Contains(err.Error(), "keyword") {
+			t.Errorf("Expected a keyword error for %q, got %v", keyword, err)
+		}
+	}
+	if _, err := InsertText("Größe", "text"); err != nil {
+		t.Errorf("Expected a Latin-1 keyword to be accepted, got %v", err)
+	}
+	if _, err := InsertText("Comment", "bad \xFF UTF-8"); err == nil || !strings.Contains(err.Error(), "UTF-8") {
+		t.Errorf("Expected a text encoding error, got %v", err)
+	}
+}
+
+func TestInsertConstructors(t *testing.T) {
+	phys, err := InsertDPI(72)
+	if err != nil {
+		t.Fatalf("Failed to build pHYs chunk: %v", err)
+	}
+	if phys.Type != "pHYs" || binary.BigEndian.Uint32(phys.Data[0:4]) != 2835 || phys.Data[8] != 1 {
+		t.Errorf("Unexpected pHYs data %v", phys.Data)
+	}
+	for _, dpi := range []float64{0, -72, 0.001, math.NaN(), math.Inf(1), math.Inf(-1), 1e9} {
+		if _, err := InsertDPI(dpi); err == nil {
+			t.Errorf("Expected an error for %v dpi", dpi)
+		}
+	}
+
+	srgb, err := InsertSRGB(IntentRelative)
+	if err != nil || srgb.Type != "sRGB" || !bytes.Equal(srgb.Data, []byte{1}) {
+		t.Errorf("Unexpected sRGB chunk %s %v: %v", srgb.Type, srgb.Data, err)
+	}
+	if _, err := InsertSRGB(4); err == nil {
+		t.Error("Expected an error for rendering intent 4")
+	}
+
+	iccp, err := InsertICCProfile("Display P3", loadTestProfile(t))
+	if err != nil {
+		t.Fatalf("Failed to build iCCP chunk: %v", err)
+	}
+	parsed, err := parseICCP(iccp.Data)
+	if err != nil || parsed.name != "Display P3" || !bytes.Equal(parsed.profile, loadTestProfile(t)) {
+		t.Errorf("iCCP chunk does not round-trip: %v", err)
+	}
+	if _, err := InsertICCProfile("Broken", []byte("not a profile")); err == nil {
+		t.Error("Expected an error for an invalid profile")
+	}
+}
+
+func TestInsert(t *testing.T) {
+	copyright, _ := InsertText("Copyright", "(c) Example")
+	iccp, _ := InsertICCProfile("Display P3", loadTestProfile(t))
+	phys, _ := InsertDPI(144)
+	srgb, _ := InsertSRGB(IntentPerceptual)
+
+	data := buildICCPNG(t,
+		makeChunk("gAMA", []byte{0, 0, 0xB1, 0x8F}),
+		makeChunk("sRGB", []byte{0}),
+		makeChunk("pHYs", []byte{0, 0, 0x2E, 0x23, 0, 0, 0x2E, 0x23, 1}),
+		makeChunk("tEXt", []byte("Copyright\x00old")),
+		makeChunk("tEXt", []byte("License\x00CC0")),
+	)
+
+	tests := []struct {
+		name     string
+		insert   []Insertion
+		expected []string
+	}{
+		{"Text", []Insertion{copyright}, []string{"IHDR", "sRGB", "pHYs", "tEXt", "tEXt", "IDAT", "IEND"}},
+		{"DPI", []Insertion{phys}, []string{"IHDR", "sRGB", "tEXt", "tEXt", "pHYs", "IDAT", "IEND"}},
+		{"sRGB", []Insertion{srgb}, []string{"IHDR", "pHYs", "tEXt", "tEXt", "sRGB", "IDAT", "IEND"}},
+		{"iCCP", []Insertion{iccp}, []string{"IHDR", "pHYs", "tEXt", "tEXt", "iCCP", "IDAT", "IEND"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.KeepText = true
+			opts.Insert = tt.insert
+
+			cleaned, result, err := StripWithOptions(data, opts)
+			if err != nil {
+				t.Fatalf("Failed to process PNG: %v", err)
+			}
+			if types := chunkTypes(cleaned); !slices.Equal(types, tt.expected) {
+				t.Errorf("Expected chunks %v, got %v", tt.expected, types)
+			}
+			if err := verifyImageIntegrity(data, cleaned); err != nil {
+				t.Errorf("Image integrity check failed: %v", err)
+			}
+
+			for _, ins := range tt.insert {
+				if !bytes.Contains(cleaned, buildChunk(ins.Type, ins.Data)) {
+					t.Errorf("Inserted %s chunk missing", ins.Type)
+				}
+			}
+			if len(result.Inserted) != len(tt.insert) || result.Inserted[0].Reason != ReasonInserted {
+				t.Errorf("Unexpected insertions %+v", result.Inserted)
+			}
+			idat := slices.IndexFunc(result.Chunks, func(info ChunkInfo) bool { return info.Type == "IDAT" })
+			if result.Inserted[0].Offset != result.Chunks[idat].Offset {
+				t.Errorf("Expected the insertion to precede IDAT at %d, got %d", result.Chunks[idat].Offset, result.Inserted[0].Offset)
+			}
+			if result.Total != len(data)-len(cleaned) {
+				t.Errorf("Total is %d, expected %d", result.Total, len(data)-len(cleaned))
+			}
+		})
+	}
+}
+
+func TestInsertStreaming(t *testing.T) {
+	phys, _ := InsertDPI(300)
+	srgb, _ := InsertSRGB(IntentPerceptual)
+	opts := DefaultOptions()
+	opts.Insert = []Insertion{phys, srgb}
+	data := buildMetadataPNG(t)
+
+	expected, _, err := StripWithOptions(data, opts)
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+	if types := chunkTypes(expected); !slices.Equal(types, []string{"IHDR", "pHYs", "sRGB", "IDAT", "IEND"}) {
+		t.Errorf("Unexpected chunks %v", types)
+	}
+
+	cleaned, err := io.ReadAll(NewReaderWithOptions(bytes.NewReader(data), opts))
+	if err != nil {
+		t.Fatalf("Failed to read stripped PNG: %v", err)
+	}
+	if !bytes.Equal(cleaned, expected) {
+		t.Error("Reader output differs from StripWithOptions output")
+	}
+}
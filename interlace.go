@@ -0,0 +1,23 @@
+package pngmetawebstrip
+
+// Interlacing selects the interlace method written by Options.Interlace
+type Interlacing int
+
+const (
+	InterlaceKeep  Interlacing = iota // Keep the interlace method of the input
+	InterlaceNone                     // Remove Adam7 interlacing
+	InterlaceAdam7                    // Interlace images of at least Options.InterlaceMinPixels pixels, remove it from others
+)
+
+// outputInterlaced reports whether an image of the given size is written
+// with Adam7 interlacing under opts, given whether the input is
+func outputInterlaced(opts Options, width, height int, input bool) bool {
+	switch opts.Interlace {
+	case InterlaceNone:
+		return false
+	case InterlaceAdam7:
+		return width*height >= opts.InterlaceMinPixels
+	default:
+		return input
+	}
+}
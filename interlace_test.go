@@ -0,0 +1,109 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestInterlace(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     bool
+		interlace Interlacing
+		minPixels int
+		expected  bool
+	}{
+		{"Remove", true, InterlaceNone, 0, false},
+		{"Add", false, InterlaceAdam7, 0, true},
+		{"Add to large image", false, InterlaceAdam7, 11 * 7, true},
+		{"Remove from small image", true, InterlaceAdam7, 11*7 + 1, false},
+		{"Keep", true, InterlaceKeep, 0, true},
+	}
+
+	for _, tt := range tests {
+		for name, img := range testImages() {
+			encoded := encodedChunks(t, img)
+			h := parseImageHeader(encoded["IHDR"])
+			r, err := decodeRaster(h, encoded["IDAT"])
+			if err != nil {
+				t.Fatalf("Failed to decode raster: %v", err)
+			}
+			r.interlaced = tt.input
+			idat, err := r.encode(adaptiveFilter)
+			if err != nil {
+				t.Fatalf("Failed to encode raster: %v", err)
+			}
+
+			ihdr := bytes.Clone(encoded["IHDR"])
+			if tt.input {
+				ihdr[12] = 1
+			}
+			chunks := [][]byte{makeChunk("IHDR", ihdr)}
+			if encoded["PLTE"] != nil {
+				chunks = append(chunks, makeChunk("PLTE", encoded["PLTE"]))
+			}
+			data := buildPNG(append(chunks, makeChunk("IDAT", idat), makeChunk("IEND", nil))...)
+
+			opts := DefaultOptions()
+			opts.Interlace = tt.interlace
+			opts.InterlaceMinPixels = tt.minPixels
+			cleaned, result, err := StripWithOptions(data, opts)
+			if err != nil {
+				t.Fatalf("%s/%s: Failed to process PNG: %v", tt.name, name, err)
+			}
+
+			if h := parseImageHeader(encodedChunksFromPNG(cleaned)["IHDR"]); h.Interlaced != tt.expected {
+				t.Errorf("%s/%s: Expected interlaced %v, got %v", tt.name, name, tt.expected, h.Interlaced)
+			}
+			if tt.input == tt.expected && !bytes.Equal(cleaned, data) {
+				t.Errorf("%s/%s: Image rewritten without a change", tt.name, name)
+			}
+			decoded, err := png.Decode(bytes.NewReader(cleaned))
+			if err != nil {
+				t.Fatalf("%s/%s: Failed to decode PNG: %v", tt.name, name, err)
+			}
+			assertSamePixels(t, img, decoded)
+			if result.Total != len(data)-len(cleaned) || result.Optimized.ImageData != result.Total {
+				t.Errorf("%s/%s: Total is %d and image data %d, expected %d",
+					tt.name, name, result.Total, result.Optimized.ImageData, len(data)-len(cleaned))
+			}
+		}
+	}
+}
+
+func TestInterlaceWithOrientation(t *testing.T) {
+	gray := func(labels [6]uint8) image.Image {
+		img := image.NewGray(image.Rect(0, 0, 3, 2))
+		for i, l := range labels {
+			img.SetGray(i%3, i/3, color.Gray{l * 40})
+		}
+		return img
+	}
+
+	// Interlace the input, keeping its orientation
+	opts := DefaultOptions()
+	opts.KeepExif = true
+	opts.Interlace = InterlaceAdam7
+	data, _, err := StripWithOptions(orientationPNG(t, gray, 6), opts)
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+
+	// Orientation keeps the interlace method unless asked otherwise
+	opts = DefaultOptions()
+	opts.ApplyOrientation = true
+	cleaned, _, err := StripWithOptions(data, opts)
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+	h := parseImageHeader(encodedChunksFromPNG(cleaned)["IHDR"])
+	if !h.Interlaced || h.Width != 2 || h.Height != 3 {
+		t.Errorf("Expected an interlaced 2x3 image, got %+v", h)
+	}
+	if _, err := png.Decode(bytes.NewReader(cleaned)); err != nil {
+		t.Errorf("Failed to decode PNG: %v", err)
+	}
+}
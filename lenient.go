@@ -0,0 +1,123 @@
+package pngmetawebstrip
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"slices"
+)
+
+// repairChunk handles a chunk whose CRC check failed in lenient mode. Chunks
+// needed to decode the image are returned with a corrected CRC when their
+// contents are valid; all other chunks are dropped and nil is returned. The
+// CRC error is returned when the chunk cannot be repaired.
+func (s *stripper) repairChunk(chunk []byte, offset int, crcErr error) ([]byte, error) {
+	chunkType := string(chunk[4:8])
+
+	if !requiredChunks[chunkType] && !animationChunks[chunkType] {
+		s.result.Chunks = append(s.result.Chunks, ChunkInfo{
+			Type:   chunkType,
+			Offset: offset,
+			Length: len(chunk) - 12,
+			Reason: ReasonBadCRC,
+		})
+		trackRemovedChunk(s.result, chunkType, len(chunk))
+		s.warn(chunkType, offset, "invalid CRC, chunk dropped")
+		s.repaired = true
+		return nil, nil
+	}
+
+	if err := validateChunkData(chunkType, chunk[8:len(chunk)-4]); err != nil {
+		return nil, errors.Join(crcErr, &ChunkError{Type: chunkType, Offset: offset, Err: err})
+	}
+
+	repaired := make([]byte, len(chunk))
+	copy(repaired, chunk)
+	end := len(repaired) - 4
+	binary.BigEndian.PutUint32(repaired[end:], crc32.ChecksumIEEE(repaired[4:end]))
+
+	s.warn(chunkType, offset, "invalid CRC, recalculated")
+	s.repaired = true
+	return repaired, nil
+}
+
+// warn records a warning in the result
+func (s *stripper) warn(chunkType string, offset int, format string, args ...any) {
+	s.result.Warnings = append(s.result.Warnings, Warning{
+		Type:    chunkType,
+		Offset:  offset,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// validateChunkData performs the structural checks that are possible on a
+// single chunk without decoding the image
+func validateChunkData(chunkType string, data []byte) error {
+	switch chunkType {
+	case "IHDR":
+		return validateIHDR(data)
+	case "PLTE":
+		if len(data) == 0 || len(data)%3 != 0 || len(data) > 256*3 {
+			return fmt.Errorf("%w: palette length %d", ErrInvalidChunk, len(data))
+		}
+	case "IEND":
+		if len(data) != 0 {
+			return fmt.Errorf("%w: IEND must be empty", ErrInvalidChunk)
+		}
+	case "acTL":
+		if len(data) != 8 {
+			return fmt.Errorf("%w: acTL length %d", ErrInvalidChunk, len(data))
+		}
+	case "fcTL":
+		if len(data) != 26 {
+			return fmt.Errorf("%w: fcTL length %d", ErrInvalidChunk, len(data))
+		}
+	case "fdAT":
+		if len(data) < 4 {
+			return fmt.Errorf("%w: too short for sequence number", ErrInvalidChunk)
+		}
+	}
+	return nil
+}
+
+// Bit depths allowed for each color type
+var allowedBitDepths = map[byte][]byte{
+	0: {1, 2, 4, 8, 16}, // Grayscale
+	2: {8, 16},          // Truecolor
+	3: {1, 2, 4, 8},     // Indexed
+	4: {8, 16},          // Grayscale with alpha
+	6: {8, 16},          // Truecolor with alpha
+}
+
+// validateIHDR checks the fields of an image header
+func validateIHDR(data []byte) error {
+	if len(data) != 13 {
+		return fmt.Errorf("%w: IHDR length %d", ErrInvalidChunk, len(data))
+	}
+	if err := validateHeader(parseImageHeader(data)); err != nil {
+		return err
+	}
+
+	if data[10] != 0 || data[11] != 0 || data[12] > 1 {
+		return fmt.Errorf("%w: invalid compression, filter or interlace method", ErrInvalidChunk)
+	}
+	return nil
+}
+
+// validateHeader checks the dimensions, color type and bit depth of a
+// parsed image header
+func validateHeader(h ImageHeader) error {
+	if h.Width <= 0 || h.Height <= 0 || h.Width > maxChunkLength || h.Height > maxChunkLength {
+		return fmt.Errorf("%w: invalid dimensions %dx%d", ErrInvalidChunk, h.Width, h.Height)
+	}
+
+	depths, ok := allowedBitDepths[byte(h.ColorType)]
+	if !ok || h.ColorType > 6 {
+		return fmt.Errorf("%w: invalid color type %d", ErrInvalidChunk, h.ColorType)
+	}
+	if !slices.Contains(depths, byte(h.BitDepth)) || h.BitDepth > 16 {
+		return fmt.Errorf("%w: invalid bit depth %d for color type %d", ErrInvalidChunk, h.BitDepth, h.ColorType)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"testing"
+)
+
+// corruptCRC breaks the CRC of the first chunk of the given type
+func corruptCRC(t *testing.T, data []byte, chunkType string) []byte {
+	t.Helper()
+
+	corrupt := bytes.Clone(data)
+	offset := 8
+	for offset+8 <= len(corrupt) {
+		length := int(binary.BigEndian.Uint32(corrupt[offset : offset+4]))
+		if string(corrupt[offset+4:offset+8]) == chunkType {
+			corrupt[offset+8+length] ^= 0xFF
+			return corrupt
+		}
+		offset += 12 + length
+	}
+
+	t.Fatalf("Chunk %s not found", chunkType)
+	return nil
+}
+
+func TestLenientDropsAncillary(t *testing.T) {
+	data := corruptCRC(t, buildMetadataPNG(t), "gAMA")
+
+	if _, _, err := Strip(data); !errors.Is(err, ErrBadCRC) {
+		t.Fatalf("Strict mode should reject bad CRC, got %v", err)
+	}
+
+	opts := DefaultOptions()
+	opts.Lenient = true
+	cleaned, result, err := StripWithOptions(data, opts)
+	if err != nil {
+		t.Fatalf("Lenient mode failed: %v", err)
+	}
+
+	if hasChunk(cleaned, "gAMA") {
+		t.Error("Expected gAMA with bad CRC to be dropped")
+	}
+	if !hasChunk(cleaned, "pHYs") {
+		t.Error("Expected valid pHYs to be kept")
+	}
+	if len(result.Warnings) != 1 || result.Warnings[0].Type != "gAMA" {
+		t.Errorf("Expected one gAMA warning, got %+v", result.Warnings)
+	}
+	if result.RemovedByType["gAMA"] != 16 {
+		t.Errorf("Expected 16 bytes of gAMA removed, got %d", result.RemovedByType["gAMA"])
+	}
+
+	if _, _, err := Strip(cleaned); err != nil {
+		t.Errorf("Lenient output failed strict validation: %v", err)
+	}
+}
+
+func TestLenientRepairsCritical(t *testing.T) {
+	original := buildMetadataPNG(t)
+
+	for _, chunkType := range []string{"IHDR", "IDAT", "IEND"} {
+		t.Run(chunkType, func(t *testing.T) {
+			data := corruptCRC(t, original, chunkType)
+
+			cleaned, result, err := StripWithOptions(data, Options{Lenient: true})
+			if err != nil {
+				t.Fatalf("Lenient mode failed: %v", err)
+			}
+
+			if !hasChunk(cleaned, chunkType) {
+				t.Errorf("Expected %s to be kept", chunkType)
+			}
+			if len(result.Warnings) != 1 || result.Warnings[0].Type != chunkType {
+				t.Errorf("Expected one %s warning, got %+v", chunkType, result.Warnings)
+			}
+
+			if _, _, err := Strip(cleaned); err != nil {
+				t.Errorf("Repaired output failed strict validation: %v", err)
+			}
+			if err := verifyImageIntegrity(original, cleaned); err != nil {
+				t.Errorf("Image integrity check failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestLenientRejectsInvalidCritical(t *testing.T) {
+	encoded := encodedChunks(t, image.NewGray(image.Rect(0, 0, 4, 4)))
+	ihdr := bytes.Clone(encoded["IHDR"])
+	ihdr[9] = 5 // No such color type
+
+	bad := makeChunk("IHDR", ihdr)
+	bad[len(bad)-1] ^= 0xFF
+	data := buildPNG(bad, makeChunk("IDAT", encoded["IDAT"]), makeChunk("IEND", nil))
+
+	_, _, err := StripWithOptions(data, Options{Lenient: true})
+	if !errors.Is(err, ErrBadCRC) || !errors.Is(err, ErrInvalidChunk) {
+		t.Errorf("Expected bad CRC and invalid chunk errors, got %v", err)
+	}
+}
+
+func TestLenientStreaming(t *testing.T) {
+	data := corruptCRC(t, buildMetadataPNG(t), "tEXt")
+	opts := Options{Lenient: true}
+
+	expected, _, err := StripWithOptions(data, opts)
+	if err != nil {
+		t.Fatalf("Lenient mode failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	w := NewWriterWithOptions(&buf, opts)
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if !bytes.Equal(buf.Bytes(), expected) || len(w.Result().Warnings) != 1 {
+		t.Error("Streaming lenient output differs from StripWithOptions")
+	}
+}
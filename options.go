@@ -0,0 +1,327 @@
+package pngmetawebstrip
+
+// Options controls which chunks are preserved by StripWithOptions.
+//
+// Chunks required to decode the image (IHDR, PLTE, IDAT, IEND, tRNS) and
+// APNG animation chunks are always preserved. For every other chunk the
+// Drop list is consulted first, then the Keep list, then the category
+// switches. Chunks matched by none of them are removed.
+type Options struct {
+	Keep []string // Chunk types that are always preserved, e.g. "bKGD"
+	Drop []string // Chunk types that are always removed, e.g. "pHYs"
+
+	KeepText     bool // tEXt, zTXt, iTXt
+	KeepTime     bool // tIME
+	KeepExif     bool // eXIf
+	KeepColor    bool // gAMA, cHRM, sRGB, iCCP, sBIT, cICP
+	KeepPhysical bool // pHYs
+
+	// KeepTextKeywords and DropTextKeywords refine KeepText for tEXt, zTXt
+	// and iTXt chunks by their case-sensitive keyword, e.g. "Copyright".
+	// A chunk whose keyword is in DropTextKeywords is removed, one in
+	// KeepTextKeywords is preserved, and all others follow KeepText.
+	KeepTextKeywords []string
+	DropTextKeywords []string
+
+	// XMPProperties lists XMP properties to preserve when the iTXt chunk
+	// holding the XMP packet (keyword "XML:com.adobe.xmp") would otherwise
+	// be removed, as "prefix:name" or "prefix:*" for a whole schema, e.g.
+	// "dc:rights" or "xmpRights:*". The packet is rebuilt with only these
+	// properties and no padding; edit history, document and instance IDs
+	// and thumbnails are dropped unless listed. Prefixes are those commonly
+	// used by XMP (dc, xmp, xmpRights, xmpMM, photoshop, tiff, exif,
+	// Iptc4xmpCore, plus, cc) or declared in the packet.
+	XMPProperties []string
+
+	// Insert lists chunks to add to the output, built with InsertText,
+	// InsertDPI, InsertSRGB or InsertICCProfile. They are placed before the
+	// first PLTE or IDAT chunk, as required for color and physical chunks,
+	// and replace kept chunks they conflict with: text chunks with the same
+	// keyword, any sRGB or iCCP chunk when one of them is inserted, and
+	// chunks of the same type otherwise.
+	Insert []Insertion
+
+	// UncompressText re-emits kept zTXt chunks as tEXt when the text takes
+	// less space uncompressed, as is common for short strings
+	UncompressText bool
+
+	// ExifTags lists EXIF tags, by name, to preserve when eXIf would
+	// otherwise be removed, e.g. "Orientation", "ColorSpace", "Copyright".
+	// The chunk is rebuilt as a minimal TIFF structure holding only these
+	// tags. Supported names are the descriptive IFD0 tags (ImageDescription,
+	// Make, Model, Orientation, XResolution, YResolution, ResolutionUnit,
+	// Software, DateTime, Artist, Copyright) and ExposureTime, FNumber,
+	// ISOSpeedRatings, ExifVersion, DateTimeOriginal, DateTimeDigitized,
+	// FocalLength, ColorSpace, PixelXDimension, PixelYDimension and
+	// LensModel. GPS data and serial numbers can never be kept. EXIF that
+	// cannot be parsed is removed.
+	ExifTags []string
+
+	// ReplaceSRGBProfile replaces kept iCCP chunks whose profile is
+	// equivalent to sRGB with a 13-byte sRGB chunk carrying the profile's
+	// rendering intent
+	ReplaceSRGBProfile bool
+
+	// MinimizeProfile rewrites other kept iCCP profiles with only the tags
+	// needed to transform colors (dropping multi-language descriptions,
+	// copyright, vendor private tags and the like) and maximum compression
+	MinimizeProfile bool
+
+	// ReconcileColor removes kept color chunks that decoders ignore because
+	// a higher ranked one is present: cICP takes precedence over iCCP, iCCP
+	// over sRGB, and any of them over gAMA and cHRM. Chunks up to the first
+	// PLTE or IDAT are held in memory to find them.
+	ReconcileColor bool
+
+	// GammaToSRGB replaces gAMA and cHRM chunks with an sRGB chunk when they
+	// are the only color information and describe sRGB (gamma 1/2.2 and, if
+	// present, the sRGB white point and primaries). Browsers apply a lone
+	// gAMA inconsistently, while sRGB renders the same everywhere.
+	GammaToSRGB bool
+
+	// DropGamma removes gAMA and cHRM chunks that are the only color
+	// information and were not replaced by GammaToSRGB, so the image renders
+	// as untagged sRGB in every browser. A warning is recorded when the
+	// values differ from sRGB.
+	DropGamma bool
+
+	// ApplyOrientation rotates and flips the pixels according to the EXIF
+	// Orientation tag, so the image displays upright once eXIf is removed.
+	// The image data is decoded and re-encoded losslessly, which requires
+	// the whole image to be held in memory. A kept eXIf chunk gets its
+	// orientation reset to normal. Animated images are left unchanged.
+	ApplyOrientation bool
+
+	// Recompress re-encodes the image data when that makes it smaller:
+	// pixels are decoded and compressed again with each fixed filter type
+	// and an adaptive filter choice at the strongest deflate level, and the
+	// smallest encoding that decodes to identical pixels replaces the IDAT
+	// chunks. The whole image is held in memory.
+	Recompress bool
+
+	// OptimalDeflateLimit makes Recompress deflate the best filtered image
+	// data once more with a Zopfli-style optimal parser when it is at most
+	// this many bytes, usually saving several percent more. That takes
+	// seconds per megabyte and around 50 bytes of memory per byte, and cannot
+	// be interrupted. 0 disables it.
+	OptimalDeflateLimit int
+
+	// ReduceColor re-encodes the image in the smallest color type and bit
+	// depth that represents every pixel exactly: grayscale for gray images,
+	// no alpha channel for opaque ones or when tRNS can mark the transparent
+	// color, a palette for up to 256 colors, lower bit depths when they hold
+	// the values exactly, and 8 bits when the low bytes of 16-bit samples
+	// are redundant. A kept ICC profile restricts the choice to its
+	// grayscale or RGB color space, and sBIT, bKGD and hIST are converted.
+	// The result is checked to decode to identical pixels and used only
+	// when the image gets smaller. The whole image is held in memory.
+	// Animated images are left unchanged.
+	ReduceColor bool
+
+	// CleanTransparent replaces the color values of fully transparent
+	// pixels in images with an alpha channel, which exporters often leave
+	// holding cropped-out or hidden content. The image looks the same but
+	// compresses better, especially with TransparentNeighbor. The image is
+	// rewritten whenever a pixel changes, and held in memory.
+	CleanTransparent TransparentFill
+
+	// Interlace changes the interlace method of the image. InterlaceNone
+	// removes Adam7 interlacing, which typically makes images 10-30%
+	// smaller, while InterlaceAdam7 interlaces images of at least
+	// InterlaceMinPixels pixels for progressive display and removes it from
+	// smaller ones. The image is decoded, re-encoded and checked to decode
+	// to identical pixels, and is held in memory. Animated images are left
+	// unchanged.
+	Interlace          Interlacing
+	InterlaceMinPixels int
+
+	// OptimizePalette rewrites the palette of indexed images, also when
+	// ReduceColor is not set: unused and duplicate entries are removed,
+	// transparent entries are moved first so that tRNS is as short as
+	// possible, trailing opaque tRNS entries are omitted and the bit depth
+	// is lowered to fit the number of entries
+	OptimizePalette bool
+
+	// MergeIDAT joins each run of consecutive IDAT chunks into a single
+	// chunk, saving 12 bytes per chunk, and drops empty IDAT chunks. The
+	// compressed data is not changed.
+	MergeIDAT bool
+
+	// SplitIDAT, when positive, re-splits the image data into IDAT chunks
+	// of this many bytes, so that decoders can start on the image before it
+	// has fully arrived. Empty IDAT chunks are dropped.
+	SplitIDAT int
+
+	// Verify decodes the input and the output of StripWithOptions and
+	// compares their pixels, returning the input unchanged with an error
+	// wrapping ErrPixelMismatch when they differ. It is implied by every
+	// option that rewrites the image data or IDAT chunks or reorders chunks,
+	// and accounts for the rotation of ApplyOrientation. Result.Verified
+	// reports whether the check ran: inputs that cannot be decoded, or have
+	// more pixels than an 8192x8192 image, are not verified. Reader and
+	// Writer do not verify; use VerifyPixelsEqual on their output instead.
+	Verify bool
+
+	// NeverGrow makes StripWithOptions return the input unchanged, with
+	// Result.KeptOriginal set, when the output is not smaller, as can happen
+	// when chunks are inserted or the image data is rewritten. This also
+	// discards changes made regardless of size, such as ApplyOrientation.
+	// Inputs that Lenient or RepairOrder repaired, or whose trailing data
+	// was dropped, are never returned unchanged. The Result then describes
+	// the input as returned: every chunk is kept with ReasonNeverGrow, the
+	// savings, insertions and pixel changes of the discarded output are
+	// cleared, and the warnings about the input are kept. Reader and Writer
+	// cannot take back what they have written and ignore it.
+	NeverGrow bool
+
+	// RepairOrder moves chunks to positions allowed by the PNG
+	// specification, as reported in Result.OrderViolations: ancillary chunks
+	// that must precede the image data are moved before the first IDAT,
+	// other chunks found between IDAT chunks after the last one, color
+	// chunks before PLTE, and tRNS, bKGD and hIST after it. PLTE itself is
+	// moved before the image data. Chunks that would then repeat one that
+	// may only appear once are dropped. Every move is recorded in
+	// Result.Warnings. The whole image is held in memory.
+	RepairOrder bool
+
+	// Lenient repairs chunks with a bad CRC instead of failing: ancillary
+	// chunks are dropped, and chunks needed for decoding get a corrected CRC
+	// when their contents are otherwise valid. Every repair is recorded in
+	// Result.Warnings.
+	Lenient bool
+
+	// RejectTrailingData fails with ErrTrailingData when bytes follow the
+	// IEND chunk, as in ZIP polyglots or installer stubs. By default such
+	// bytes are dropped and reported in Result.TrailingData.
+	RejectTrailingData bool
+}
+
+// DefaultOptions returns the web policy used by Strip: color and physical
+// dimension chunks are preserved, sRGB profiles are replaced by an sRGB
+// chunk, other profiles are minimized, and all other metadata is removed.
+func DefaultOptions() Options {
+	return Options{
+		KeepColor:          true,
+		KeepPhysical:       true,
+		ReplaceSRGBProfile: true,
+		MinimizeProfile:    true,
+		ReconcileColor:     true,
+	}
+}
+
+// Chunks that must be preserved to decode the image correctly
+var requiredChunks = map[string]bool{
+	// Core
+	"IHDR": true,
+	"PLTE": true,
+	"IDAT": true,
+	"IEND": true,
+	// Transparency
+	"tRNS": true,
+}
+
+// Chunks covered by the category switches of Options
+var (
+	textChunks  = map[string]bool{"tEXt": true, "zTXt": true, "iTXt": true}
+	colorChunks = map[string]bool{"gAMA": true, "cHRM": true, "sRGB": true, "iCCP": true, "sBIT": true, "cICP": true}
+)
+
+// policy is the compiled form of Options used while processing chunks
+type policy struct {
+	opts Options
+	keep map[string]bool
+	drop map[string]bool
+
+	// Text keywords
+	keepText map[string]bool
+	dropText map[string]bool
+
+	// Types and text keywords of Options.Insert
+	inserted     map[string]bool
+	insertedText map[string]bool
+}
+
+func newPolicy(opts Options) *policy {
+	p := &policy{
+		opts:     opts,
+		keep:     make(map[string]bool, len(opts.Keep)),
+		drop:     make(map[string]bool, len(opts.Drop)),
+		keepText: make(map[string]bool, len(opts.KeepTextKeywords)),
+		dropText: make(map[string]bool, len(opts.DropTextKeywords)),
+
+		inserted:     make(map[string]bool, len(opts.Insert)),
+		insertedText: make(map[string]bool, len(opts.Insert)),
+	}
+	for _, chunkType := range opts.Keep {
+		p.keep[chunkType] = true
+	}
+	for _, chunkType := range opts.Drop {
+		p.drop[chunkType] = true
+	}
+	for _, keyword := range opts.KeepTextKeywords {
+		p.keepText[keyword] = true
+	}
+	for _, keyword := range opts.DropTextKeywords {
+		p.dropText[keyword] = true
+	}
+	for _, ins := range opts.Insert {
+		p.inserted[ins.Type] = true
+		if textChunks[ins.Type] {
+			p.insertedText[textKeyword(ins.Data)] = true
+		}
+	}
+	return p
+}
+
+// Reasons recorded in ChunkInfo for the decision taken on a chunk
+const (
+	ReasonRequired    = "required"     // Needed to decode the image
+	ReasonAnimation   = "animation"    // APNG animation chunk
+	ReasonDropList    = "drop list"    // Listed in Options.Drop
+	ReasonKeepList    = "keep list"    // Listed in Options.Keep
+	ReasonText        = "text"         // Decided by Options.KeepText
+	ReasonTime        = "time"         // Decided by Options.KeepTime
+	ReasonExif        = "exif"         // Decided by Options.KeepExif
+	ReasonColor       = "color"        // Decided by Options.KeepColor
+	ReasonPhysical    = "physical"     // Decided by Options.KeepPhysical
+	ReasonOther       = "other"        // Not covered by any option
+	ReasonBadCRC      = "bad CRC"      // Dropped in lenient mode because of a bad CRC
+	ReasonDuplicate   = "duplicate"    // Repeats a chunk that may only appear once
+	ReasonSRGB        = "sRGB"         // Replaced by an equivalent sRGB chunk
+	ReasonSuperseded  = "superseded"   // Color chunk ignored in favor of a higher ranked one
+	ReasonGamma       = "gamma"        // Dropped by Options.DropGamma
+	ReasonExifTags    = "exif tags"    // eXIf rebuilt with Options.ExifTags
+	ReasonTextKeyword = "text keyword" // Decided by Options.KeepTextKeywords or DropTextKeywords
+	ReasonXMP         = "xmp"          // XMP packet rebuilt with Options.XMPProperties
+	ReasonInserted    = "inserted"     // Added by Options.Insert
+	ReasonReplaced    = "replaced"     // Replaced by a chunk of Options.Insert
+	ReasonEmpty       = "empty"        // Empty IDAT dropped by Options.MergeIDAT or SplitIDAT
+	ReasonMisplaced   = "misplaced"    // Out of order chunk dropped by Options.RepairOrder
+	ReasonNeverGrow   = "never grow"   // Kept because Options.NeverGrow returned the input unchanged
+)
+
+// shouldKeepChunk determines if a chunk should be preserved and why
+func (p *policy) shouldKeepChunk(chunkType string) (bool, string) {
+	switch {
+	case requiredChunks[chunkType]:
+		return true, ReasonRequired
+	case animationChunks[chunkType]:
+		return true, ReasonAnimation
+	case p.drop[chunkType]:
+		return false, ReasonDropList
+	case p.keep[chunkType]:
+		return true, ReasonKeepList
+	case textChunks[chunkType]:
+		return p.opts.KeepText, ReasonText
+	case colorChunks[chunkType]:
+		return p.opts.KeepColor, ReasonColor
+	case chunkType == "tIME":
+		return p.opts.KeepTime, ReasonTime
+	case chunkType == "eXIf":
+		return p.opts.KeepExif, ReasonExif
+	case chunkType == "pHYs":
+		return p.opts.KeepPhysical, ReasonPhysical
+	default:
+		return false, ReasonOther
+	}
+}
@@ -0,0 +1,287 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"image"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// buildMetadataPNG creates a PNG carrying one chunk of every category
+func buildMetadataPNG(t *testing.T) []byte {
+	t.Helper()
+
+	encoded := encodedChunks(t, image.NewGray(image.Rect(0, 0, 4, 4)))
+	return buildPNG(
+		makeChunk("IHDR", encoded["IHDR"]),
+		makeChunk("gAMA", []byte{0, 0, 0xB1, 0x8F}),
+		makeChunk("pHYs", []byte{0, 0, 0x2E, 0x23, 0, 0, 0x2E, 0x23, 1}),
+		makeChunk("bKGD", []byte{0, 0}),
+		makeChunk("tIME", []byte{0x07, 0xE8, 1, 1, 0, 0, 0}),
+		makeChunk("eXIf", []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x00")),
+		makeChunk("tEXt", []byte("Comment\x00hello")),
+		makeChunk("IDAT", encoded["IDAT"]),
+		makeChunk("IEND", nil),
+	)
+}
+
+func TestStripWithOptions(t *testing.T) {
+	data := buildMetadataPNG(t)
+
+	tests := []struct {
+		name    string
+		opts    Options
+		present []string
+		absent  []string
+	}{
+		{
+			name:    "Default web policy",
+			opts:    DefaultOptions(),
+			present: []string{"gAMA", "pHYs"},
+			absent:  []string{"bKGD", "tIME", "eXIf", "tEXt"},
+		},
+		{
+			name:    "Keep list",
+			opts:    Options{Keep: []string{"bKGD"}},
+			present: []string{"bKGD"},
+			absent:  []string{"gAMA", "pHYs", "tIME", "eXIf", "tEXt"},
+		},
+		{
+			name:    "Drop list overrides category",
+			opts:    Options{KeepColor: true, KeepPhysical: true, Drop: []string{"pHYs"}},
+			present: []string{"gAMA"},
+			absent:  []string{"pHYs"},
+		},
+		{
+			name:   "Drop list overrides keep list",
+			opts:   Options{Keep: []string{"bKGD"}, Drop: []string{"bKGD"}},
+			absent: []string{"bKGD"},
+		},
+		{
+			name:    "Category switches",
+			opts:    Options{KeepText: true, KeepTime: true, KeepExif: true},
+			present: []string{"tEXt", "tIME", "eXIf"},
+			absent:  []string{"gAMA", "pHYs", "bKGD"},
+		},
+		{
+			name:    "Required chunks cannot be dropped",
+			opts:    Options{Drop: []string{"IHDR", "IDAT", "IEND"}},
+			present: []string{"IHDR", "IDAT", "IEND"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cleaned, result, err := StripWithOptions(data, tt.opts)
+			if err != nil {
+				t.Fatalf("Failed to process PNG: %v", err)
+			}
+
+			for _, chunkType := range tt.present {
+				if !hasChunk(cleaned, chunkType) {
+					t.Errorf("Expected chunk %s to be kept", chunkType)
+				}
+			}
+			for _, chunkType := range tt.absent {
+				if hasChunk(cleaned, chunkType) {
+					t.Errorf("Expected chunk %s to be removed", chunkType)
+				}
+			}
+
+			if result.Total != len(data)-len(cleaned) {
+				t.Errorf("Total %d does not match size difference %d", result.Total, len(data)-len(cleaned))
+			}
+
+			if err := verifyImageIntegrity(data, cleaned); err != nil {
+				t.Errorf("Image integrity check failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestReaderWriterWithOptions(t *testing.T) {
+	data := buildMetadataPNG(t)
+	opts := Options{Keep: []string{"bKGD"}}
+
+	cleaned, _, err := PngMetaWebStripReaderWithOptions(bytes.NewReader(data), opts)
+	if err != nil {
+		t.Fatalf("Failed to process PNG from reader: %v", err)
+	}
+	if !hasChunk(cleaned, "bKGD") {
+		t.Error("Reader did not honour the keep list")
+	}
+
+	var buf bytes.Buffer
+	if _, err := PngMetaWebStripWriterWithOptions(data, &buf, opts); err != nil {
+		t.Fatalf("Failed to process PNG to writer: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), cleaned) {
+		t.Error("Writer and reader produced different output")
+	}
+}
+
+func TestNeverGrow(t *testing.T) {
+	data := buildMetadataPNG(t)
+	rotated := orientationPNG(t, func(labels [6]uint8) image.Image {
+		img := image.NewGray(image.Rect(0, 0, 3, 2))
+		copy(img.Pix, labels[:])
+		return img
+	}, 6)
+	all := []string{"gAMA", "pHYs", "bKGD", "tIME", "eXIf", "tEXt"}
+	comment, err := InsertText("Comment", strings.Repeat("long comment ", 20))
+	if err != nil {
+		t.Fatalf("Failed to build text chunk: %v", err)
+	}
+
+	gray := encodedChunks(t, image.NewGray(image.Rect(0, 0, 4, 4)))
+	malformed := buildPNG(makeChunk("IHDR", gray["IHDR"]), makeChunk("eXIf", []byte("garbage")),
+		makeChunk("IDAT", gray["IDAT"]), makeChunk("IEND", nil))
+
+	tests := []struct {
+		name     string
+		data     []byte
+		opts     Options
+		original bool
+		warnings int
+	}{
+		{"smaller", data, Options{NeverGrow: true}, false, 0},
+		{"same size", data, Options{NeverGrow: true, Keep: all}, true, 0},
+		{"larger", data, Options{NeverGrow: true, Keep: all, Insert: []Insertion{comment}}, true, 0},
+		{"larger despite removals", data, Options{NeverGrow: true, Insert: []Insertion{comment}}, true, 0},
+		{"rotated and larger", rotated, Options{NeverGrow: true, ApplyOrientation: true, Insert: []Insertion{comment}}, true, 0},
+		{"larger with warnings", malformed, Options{NeverGrow: true, ExifTags: []string{"Orientation"}, Insert: []Insertion{comment}}, true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cleaned, result, err := StripWithOptions(tt.data, tt.opts)
+			if err != nil {
+				t.Fatalf("Failed to process PNG: %v", err)
+			}
+			if result.KeptOriginal != tt.original {
+				t.Errorf("Expected KeptOriginal %v, got %v", tt.original, result.KeptOriginal)
+			}
+			if len(cleaned) > len(tt.data) || result.Total != len(tt.data)-len(cleaned) {
+				t.Errorf("Output grew from %d to %d bytes, Total %d", len(tt.data), len(cleaned), result.Total)
+			}
+			if !tt.original {
+				return
+			}
+
+			// The result describes the input returned unchanged
+			if !bytes.Equal(cleaned, tt.data) {
+				t.Error("Original bytes not returned")
+			}
+			for _, info := range result.Chunks {
+				if !info.Kept || info.Reason != ReasonNeverGrow {
+					t.Errorf("Unexpected chunk log entry %+v", info)
+				}
+			}
+			if len(result.Chunks) != len(chunkTypes(tt.data)) {
+				t.Errorf("Expected %d chunk log entries, got %d", len(chunkTypes(tt.data)), len(result.Chunks))
+			}
+			if result.Removed != (Result{}).Removed || result.Optimized != (Result{}).Optimized ||
+				len(result.RemovedByType) > 0 || len(result.Inserted) > 0 {
+				t.Errorf("Savings of the discarded output reported: %+v", result)
+			}
+			if len(result.Warnings) != tt.warnings {
+				t.Errorf("Expected %d warnings about the input, got %v", tt.warnings, result.Warnings)
+			}
+			if result.PixelTransform || result.Orientation != 0 || result.TransparentPixels != 0 {
+				t.Error("Pixel changes of the discarded output reported")
+			}
+		})
+	}
+}
+
+func TestNeverGrowKeepsRepairs(t *testing.T) {
+	comment, err := InsertText("Comment", strings.Repeat("long comment ", 20))
+	if err != nil {
+		t.Fatalf("Failed to build text chunk: %v", err)
+	}
+	trailing, err := os.ReadFile(filepath.Join("testdata", "with_trailing_data.png"))
+	if err != nil {
+		t.Skip("Test file not found")
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		opts Options
+	}{
+		{"Repaired CRC", corruptCRC(t, buildMetadataPNG(t), "IDAT"), Options{NeverGrow: true, Lenient: true, Insert: []Insertion{comment}}},
+		{"Trailing data", trailing, Options{NeverGrow: true, Insert: []Insertion{comment}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cleaned, result, err := StripWithOptions(tt.data, tt.opts)
+			if err != nil {
+				t.Fatalf("Failed to process PNG: %v", err)
+			}
+			if result.KeptOriginal || bytes.Equal(cleaned, tt.data) {
+				t.Fatal("Input returned unchanged, undoing the repair")
+			}
+			if result.Total != len(tt.data)-len(cleaned) {
+				t.Errorf("Total is %d, expected %d", result.Total, len(tt.data)-len(cleaned))
+			}
+
+			// The output is valid without leniency and ends at IEND
+			strict, err := Analyze(cleaned)
+			if err != nil {
+				t.Fatalf("Output rejected by a strict parse: %v", err)
+			}
+			if strict.HasTrailingData {
+				t.Error("Trailing data returned")
+			}
+		})
+	}
+}
+
+func TestEntryPointsAgree(t *testing.T) {
+	comment, err := InsertText("Comment", strings.Repeat("long comment ", 20))
+	if err != nil {
+		t.Fatalf("Failed to build text chunk: %v", err)
+	}
+	data := buildMetadataPNG(t)
+
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"NeverGrow", Options{NeverGrow: true, Insert: []Insertion{comment}}},
+		{"Verify", Options{Verify: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expected, expectedResult, err := StripWithOptions(data, tt.opts)
+			if err != nil {
+				t.Fatalf("Failed to process PNG: %v", err)
+			}
+			if !expectedResult.KeptOriginal && !expectedResult.Verified {
+				t.Fatalf("Expected the %s check to apply", tt.name)
+			}
+
+			cleaned, result, err := PngMetaWebStripReaderWithOptions(bytes.NewReader(data), tt.opts)
+			if err != nil {
+				t.Fatalf("Reader failed: %v", err)
+			}
+			if !bytes.Equal(cleaned, expected) || !reflect.DeepEqual(result, expectedResult) {
+				t.Errorf("Reader returned %d bytes and %+v, expected %d bytes and %+v", len(cleaned), result, len(expected), expectedResult)
+			}
+
+			var buf bytes.Buffer
+			result, err = PngMetaWebStripWriterWithOptions(data, &buf, tt.opts)
+			if err != nil {
+				t.Fatalf("Writer failed: %v", err)
+			}
+			if !bytes.Equal(buf.Bytes(), expected) || !reflect.DeepEqual(result, expectedResult) {
+				t.Errorf("Writer returned %d bytes and %+v, expected %d bytes and %+v", buf.Len(), result, len(expected), expectedResult)
+			}
+		})
+	}
+}
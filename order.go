@@ -0,0 +1,166 @@
+package pngmetawebstrip
+
+// Chunks that must appear before the first IDAT chunk. Color chunks must
+// also precede PLTE, and paletteChunks follow it when it is present.
+var (
+	paletteChunks = map[string]bool{"tRNS": true, "bKGD": true, "hIST": true}
+	imageChunks   = map[string]bool{
+		"PLTE": true, "pHYs": true, "sPLT": true, "acTL": true, "mDCV": true, "cLLI": true,
+		"oFFs": true, "pCAL": true, "sCAL": true, "sTER": true,
+	}
+)
+
+// precedesIDAT reports whether a chunk type must appear before the first
+// IDAT chunk
+func precedesIDAT(chunkType string) bool {
+	return colorChunks[chunkType] || paletteChunks[chunkType] || imageChunks[chunkType]
+}
+
+// orderState tracks the input chunks seen so far to check their order
+type orderState struct {
+	plte      bool        // PLTE has been seen
+	idat      bool        // IDAT has been seen
+	idatEnded bool        // Another chunk followed an IDAT chunk
+	palette   []ChunkInfo // Palette chunks seen before PLTE
+}
+
+// holdsChunks reports whether opts needs every chunk up to IEND held
+func holdsChunks(opts Options) bool {
+	return holdsImage(opts) || opts.RepairOrder
+}
+
+// checkOrder records in Result.OrderViolations where an input chunk breaks
+// the ordering rules of the PNG specification
+func (s *stripper) checkOrder(chunkType string, offset int) {
+	o := &s.order
+	switch {
+	case chunkType == "IDAT" && o.idatEnded:
+		s.orderViolation(chunkType, offset, "IDAT chunks must be consecutive")
+	case precedesIDAT(chunkType) && o.idat:
+		s.orderViolation(chunkType, offset, "must precede IDAT")
+	case colorChunks[chunkType] && o.plte:
+		s.orderViolation(chunkType, offset, "must precede PLTE")
+	case paletteChunks[chunkType] && !o.plte:
+		// Only a violation once a PLTE chunk follows
+		o.palette = append(o.palette, ChunkInfo{Type: chunkType, Offset: offset})
+	}
+
+	switch chunkType {
+	case "PLTE":
+		for _, c := range o.palette {
+			s.orderViolation(c.Type, c.Offset, "must follow PLTE")
+		}
+		o.plte, o.palette = true, nil
+	case "IDAT":
+		o.idat = true
+	case "IEND":
+	default:
+		o.idatEnded = o.idatEnded || o.idat
+	}
+}
+
+// orderViolation records a chunk out of order in the input
+func (s *stripper) orderViolation(chunkType string, offset int, message string) {
+	s.result.OrderViolations = append(s.result.OrderViolations, Warning{
+		Type:    chunkType,
+		Offset:  offset,
+		Message: message,
+	})
+}
+
+// repairOrder moves the held chunks to positions allowed by the PNG
+// specification: chunks that must precede the image data are moved before
+// the first IDAT chunk, other chunks interrupting the IDAT chunks after the
+// last one, color chunks before PLTE and palette chunks after it. Chunks
+// that would repeat one that may only appear once are dropped instead.
+func (s *stripper) repairOrder(held []heldChunk) []heldChunk {
+	first, last := -1, -1
+	for i, c := range held {
+		if c.chunkType() == "IDAT" {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first < 0 {
+		return s.repairPalette(held)
+	}
+
+	header := append([]heldChunk(nil), held[:first]...)
+	present := map[string]bool{}
+	for _, c := range header {
+		present[c.chunkType()] = true
+	}
+
+	var idat, trailer []heldChunk
+	for i, c := range held[first:] {
+		chunkType := c.chunkType()
+		switch {
+		case chunkType == "IDAT":
+			idat = append(idat, c)
+		case precedesIDAT(chunkType) && present[chunkType] && chunkType != "sPLT":
+			s.warnHeld(c, "dropped, repeats an earlier chunk")
+			s.discardHeld(c, ReasonMisplaced)
+		case precedesIDAT(chunkType):
+			s.warnHeld(c, "moved before the first IDAT")
+			header = append(header, c)
+			present[chunkType] = true
+		default:
+			if first+i < last {
+				s.warnHeld(c, "moved after the last IDAT")
+			}
+			trailer = append(trailer, c)
+		}
+	}
+
+	repaired := make([]heldChunk, 0, len(held))
+	repaired = append(repaired, s.repairPalette(header)...)
+	repaired = append(repaired, idat...)
+	return append(repaired, trailer...)
+}
+
+// repairPalette moves the color chunks of the held chunks before the first
+// PLTE and palette chunks after it, dropping repeated PLTE chunks
+func (s *stripper) repairPalette(held []heldChunk) []heldChunk {
+	at := -1
+	for i, c := range held {
+		if c.chunkType() == "PLTE" {
+			at = i
+			break
+		}
+	}
+	if at < 0 {
+		return held
+	}
+
+	var before, moved, after []heldChunk
+	for i, c := range held {
+		chunkType := c.chunkType()
+		switch {
+		case i == at:
+		case chunkType == "PLTE":
+			s.warnHeld(c, "dropped, repeats an earlier chunk")
+			s.discardHeld(c, ReasonMisplaced)
+		case i > at && colorChunks[chunkType]:
+			s.warnHeld(c, "moved before PLTE")
+			before = append(before, c)
+		case i < at && paletteChunks[chunkType]:
+			s.warnHeld(c, "moved after PLTE")
+			moved = append(moved, c)
+		case i < at:
+			before = append(before, c)
+		default:
+			after = append(after, c)
+		}
+	}
+	before = append(before, held[at])
+	return append(append(before, moved...), after...)
+}
+
+// warnHeld records a warning about a held chunk moved or dropped by
+// Options.RepairOrder
+func (s *stripper) warnHeld(c heldChunk, message string) {
+	s.warn(c.chunkType(), s.result.Chunks[c.info].Offset, "%s", message)
+	s.repaired = true
+}
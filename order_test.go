@@ -0,0 +1,137 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"slices"
+	"testing"
+)
+
+// orderChunks returns the chunks of a small indexed image with transparency,
+// with its image data split in two IDAT chunks
+func orderChunks(t *testing.T) map[string][]byte {
+	t.Helper()
+
+	img := image.NewPaletted(image.Rect(0, 0, 8, 8), color.Palette{
+		color.NRGBA{255, 0, 0, 255},
+		color.NRGBA{0, 0, 0, 0},
+	})
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i % 3 % 2)
+	}
+
+	encoded := encodedChunks(t, img)
+	idat := encoded["IDAT"]
+	return map[string][]byte{
+		"IHDR":  makeChunk("IHDR", encoded["IHDR"]),
+		"PLTE":  makeChunk("PLTE", encoded["PLTE"]),
+		"tRNS":  makeChunk("tRNS", encoded["tRNS"]),
+		"IDAT":  makeChunk("IDAT", idat[:len(idat)/2]),
+		"IDAT2": makeChunk("IDAT", idat[len(idat)/2:]),
+		"gAMA":  makeChunk("gAMA", []byte{0, 0, 0xB1, 0x8F}),
+		"pHYs":  makeChunk("pHYs", []byte{0, 0, 0x2E, 0x23, 0, 0, 0x2E, 0x23, 1}),
+		"tEXt":  makeChunk("tEXt", []byte("Comment\x00hello")),
+		"IEND":  makeChunk("IEND", nil),
+	}
+}
+
+// orderedPNG builds a PNG from the named chunks of orderChunks
+func orderedPNG(chunks map[string][]byte, names ...string) []byte {
+	var list [][]byte
+	for _, name := range names {
+		list = append(list, chunks[name])
+	}
+	return buildPNG(list...)
+}
+
+func TestCheckOrder(t *testing.T) {
+	chunks := orderChunks(t)
+
+	tests := []struct {
+		name       string
+		chunks     []string
+		violations []string
+	}{
+		{"valid", []string{"IHDR", "gAMA", "PLTE", "tRNS", "pHYs", "IDAT", "IDAT2", "tEXt", "IEND"}, nil},
+		{"gAMA after PLTE", []string{"IHDR", "PLTE", "gAMA", "tRNS", "IDAT", "IDAT2", "IEND"},
+			[]string{"gAMA must precede PLTE"}},
+		{"tRNS before PLTE", []string{"IHDR", "tRNS", "PLTE", "IDAT", "IDAT2", "IEND"},
+			[]string{"tRNS must follow PLTE"}},
+		{"IDAT not consecutive", []string{"IHDR", "PLTE", "tRNS", "IDAT", "tEXt", "IDAT2", "IEND"},
+			[]string{"IDAT IDAT chunks must be consecutive"}},
+		{"PLTE after IDAT", []string{"IHDR", "tRNS", "IDAT", "IDAT2", "PLTE", "pHYs", "IEND"},
+			[]string{"PLTE must precede IDAT", "tRNS must follow PLTE", "pHYs must precede IDAT"}},
+	}
+
+	opts := DefaultOptions()
+	opts.Keep = []string{"gAMA", "pHYs", "tEXt"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := orderedPNG(chunks, tt.chunks...)
+			cleaned, result, err := StripWithOptions(data, opts)
+			if err != nil {
+				t.Fatalf("Failed to process PNG: %v", err)
+			}
+
+			var violations []string
+			for _, v := range result.OrderViolations {
+				violations = append(violations, v.Type+" "+v.Message)
+			}
+			if !slices.Equal(violations, tt.violations) {
+				t.Errorf("Expected violations %q, got %q", tt.violations, violations)
+			}
+			if !bytes.Equal(cleaned, data) {
+				t.Error("Chunks changed without Options.RepairOrder")
+			}
+		})
+	}
+}
+
+func TestRepairOrder(t *testing.T) {
+	chunks := orderChunks(t)
+	data := orderedPNG(chunks, "IHDR", "tRNS", "IDAT", "tEXt", "IDAT2", "PLTE", "gAMA", "pHYs", "gAMA", "IEND")
+
+	opts := Options{RepairOrder: true, Keep: []string{"gAMA", "pHYs", "tEXt"}}
+	cleaned, result, err := StripWithOptions(data, opts)
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+
+	expected := []string{"IHDR", "gAMA", "PLTE", "tRNS", "pHYs", "IDAT", "IDAT", "tEXt", "IEND"}
+	if types := chunkTypes(cleaned); !slices.Equal(types, expected) {
+		t.Errorf("Expected chunks %v, got %v", expected, types)
+	}
+	if info := result.Chunks[8]; info.Type != "gAMA" || info.Kept || info.Reason != ReasonMisplaced {
+		t.Errorf("Repeated gAMA not dropped: %+v", info)
+	}
+	if len(result.Warnings) != 7 {
+		t.Errorf("Expected 7 warnings, got %v", result.Warnings)
+	}
+	if result.Total != len(data)-len(cleaned) {
+		t.Errorf("Total is %d, expected %d", result.Total, len(data)-len(cleaned))
+	}
+	if _, err := png.Decode(bytes.NewReader(cleaned)); err != nil {
+		t.Errorf("Failed to decode repaired PNG: %v", err)
+	}
+	if !result.Verified {
+		t.Error("Repaired PNG not verified")
+	}
+
+	_, again, err := StripWithOptions(cleaned, opts)
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+	if len(again.OrderViolations) > 0 {
+		t.Errorf("Repaired PNG still out of order: %v", again.OrderViolations)
+	}
+
+	streamed, _, err := PngMetaWebStripReaderWithOptions(bytes.NewReader(data), opts)
+	if err != nil {
+		t.Fatalf("Failed to process PNG from reader: %v", err)
+	}
+	if !bytes.Equal(streamed, cleaned) {
+		t.Error("Reader and StripWithOptions produced different output")
+	}
+}
@@ -0,0 +1,64 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// transposedPhysical swaps the horizontal and vertical resolution of a pHYs
+// chunk for images rotated by 90 degrees
+func transposedPhysical(chunk []byte) []byte {
+	data := bytes.Clone(chunk[8 : len(chunk)-4])
+	if len(data) != 9 {
+		return chunk
+	}
+	x, y := binary.BigEndian.Uint32(data[0:4]), binary.BigEndian.Uint32(data[4:8])
+	if x == y {
+		return chunk
+	}
+	binary.BigEndian.PutUint32(data[0:4], y)
+	binary.BigEndian.PutUint32(data[4:8], x)
+	return buildChunk("pHYs", data)
+}
+
+// orient returns a copy of the raster rotated and flipped so that it
+// displays upright without an EXIF orientation (2-8)
+func (r *raster) orient(orientation int) *raster {
+	dst := &raster{width: r.width, height: r.height, bitDepth: r.bitDepth, colorType: r.colorType, interlaced: r.interlaced}
+	if orientation >= 5 {
+		dst.width, dst.height = r.height, r.width
+	}
+
+	size := r.pixelSize()
+	dst.pix = make([]byte, len(r.pix))
+	for y := 0; y < dst.height; y++ {
+		for x := 0; x < dst.width; x++ {
+			sx, sy := orientedSource(orientation, x, y, r.width, r.height)
+			copy(dst.pix[(y*dst.width+x)*size:], r.pix[(sy*r.width+sx)*size:(sy*r.width+sx+1)*size])
+		}
+	}
+	return dst
+}
+
+// orientedSource maps a pixel of the upright image to the stored pixel of a
+// w by h image with the given EXIF orientation
+func orientedSource(orientation, x, y, w, h int) (int, int) {
+	switch orientation {
+	case 2: // Mirrored horizontally
+		return w - 1 - x, y
+	case 3: // Rotated 180 degrees
+		return w - 1 - x, h - 1 - y
+	case 4: // Mirrored vertically
+		return x, h - 1 - y
+	case 5: // Mirrored along the top-left to bottom-right diagonal
+		return y, x
+	case 6: // Needs a 90 degree clockwise rotation
+		return y, h - 1 - x
+	case 7: // Mirrored along the top-right to bottom-left diagonal
+		return w - 1 - y, h - 1 - x
+	case 8: // Needs a 90 degree counterclockwise rotation
+		return w - 1 - y, x
+	default:
+		return x, y
+	}
+}
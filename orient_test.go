@@ -0,0 +1,226 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"testing"
+)
+
+// orientationPNG builds a 3x2 image labeled
+//
+//	1 2 3
+//	4 5 6
+//
+// with an eXIf chunk holding the orientation
+func orientationPNG(t *testing.T, img func(labels [6]uint8) image.Image, orientation uint16, extra ...[]byte) []byte {
+	t.Helper()
+
+	encoded := encodedChunks(t, img([6]uint8{1, 2, 3, 4, 5, 6}))
+	chunks := [][]byte{makeChunk("IHDR", encoded["IHDR"])}
+	if encoded["PLTE"] != nil {
+		chunks = append(chunks, makeChunk("PLTE", encoded["PLTE"]))
+	}
+	chunks = append(chunks, extra...)
+	chunks = append(chunks,
+		makeChunk("eXIf", exifPayload(binary.BigEndian, orientation)),
+		makeChunk("IDAT", encoded["IDAT"]),
+		makeChunk("IEND", nil),
+	)
+	return buildPNG(chunks...)
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// Expected labels of the upright image, row by row
+	upright := map[uint16][][]uint8{
+		1: {{1, 2, 3}, {4, 5, 6}},
+		2: {{3, 2, 1}, {6, 5, 4}},
+		3: {{6, 5, 4}, {3, 2, 1}},
+		4: {{4, 5, 6}, {1, 2, 3}},
+		5: {{1, 4}, {2, 5}, {3, 6}},
+		6: {{4, 1}, {5, 2}, {6, 3}},
+		7: {{6, 3}, {5, 2}, {4, 1}},
+		8: {{3, 6}, {2, 5}, {1, 4}},
+	}
+
+	palette := color.Palette{}
+	for i := 0; i < 7; i++ {
+		palette = append(palette, color.Gray{uint8(i * 40)})
+	}
+	images := map[string]func(labels [6]uint8) image.Image{
+		"gray": func(labels [6]uint8) image.Image {
+			img := image.NewGray(image.Rect(0, 0, 3, 2))
+			for i, l := range labels {
+				img.SetGray(i%3, i/3, color.Gray{l * 40})
+			}
+			return img
+		},
+		"paletted": func(labels [6]uint8) image.Image {
+			img := image.NewPaletted(image.Rect(0, 0, 3, 2), palette)
+			for i, l := range labels {
+				img.SetColorIndex(i%3, i/3, l)
+			}
+			return img
+		},
+		"nrgba64": func(labels [6]uint8) image.Image {
+			img := image.NewNRGBA64(image.Rect(0, 0, 3, 2))
+			for i, l := range labels {
+				v := uint16(l) * 40 * 257
+				img.SetNRGBA64(i%3, i/3, color.NRGBA64{v, v, v, 0xFFFF - uint16(l)})
+			}
+			return img
+		},
+	}
+
+	opts := DefaultOptions()
+	opts.ApplyOrientation = true
+
+	for name, img := range images {
+		for orientation, rows := range upright {
+			data := orientationPNG(t, img, orientation)
+			cleaned, result, err := StripWithOptions(data, opts)
+			if err != nil {
+				t.Fatalf("%s/%d: failed to process PNG: %v", name, orientation, err)
+			}
+
+			decoded, err := png.Decode(bytes.NewReader(cleaned))
+			if err != nil {
+				t.Fatalf("%s/%d: failed to decode output: %v", name, orientation, err)
+			}
+			if size := decoded.Bounds().Size(); size.X != len(rows[0]) || size.Y != len(rows) {
+				t.Fatalf("%s/%d: expected %dx%d, got %v", name, orientation, len(rows[0]), len(rows), size)
+			}
+
+			reference := img([6]uint8{1, 2, 3, 4, 5, 6})
+			for y, row := range rows {
+				for x, label := range row {
+					l := int(label) - 1
+					expected := color.NRGBA64Model.Convert(reference.At(l%3, l/3))
+					if got := color.NRGBA64Model.Convert(decoded.At(x, y)); got != expected {
+						t.Errorf("%s/%d: pixel (%d, %d) is %v, expected label %d", name, orientation, x, y, got, label)
+					}
+				}
+			}
+
+			if orientation == 1 {
+				if result.PixelTransform || result.Orientation != 0 {
+					t.Errorf("%s/1: expected no pixel transform", name)
+				}
+				continue
+			}
+			if !result.PixelTransform || result.Orientation != int(orientation) {
+				t.Errorf("%s/%d: expected the orientation to be reported, got %d", name, orientation, result.Orientation)
+			}
+			if hasChunk(cleaned, "eXIf") {
+				t.Errorf("%s/%d: expected eXIf to be removed", name, orientation)
+			}
+			if result.Total != len(data)-len(cleaned) {
+				t.Errorf("%s/%d: Total is %d, expected %d", name, orientation, result.Total, len(data)-len(cleaned))
+			}
+		}
+	}
+}
+
+func TestApplyOrientationKeptChunks(t *testing.T) {
+	gray := func(labels [6]uint8) image.Image {
+		img := image.NewGray(image.Rect(0, 0, 3, 2))
+		for i, l := range labels {
+			img.SetGray(i%3, i/3, color.Gray{l})
+		}
+		return img
+	}
+	phys := makeChunk("pHYs", []byte{0, 0, 0x0B, 0x12, 0, 0, 0x16, 0x24, 1})
+	data := orientationPNG(t, gray, 6, phys)
+
+	opts := DefaultOptions()
+	opts.ApplyOrientation = true
+	opts.KeepExif = true
+
+	cleaned, _, err := StripWithOptions(data, opts)
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+
+	// A kept eXIf must not rotate the image a second time
+	exif := encodedChunksFromPNG(cleaned)["eXIf"]
+	if got := exifOrientation(exif); got != 1 {
+		t.Errorf("Expected the kept eXIf orientation to be reset, got %d", got)
+	}
+	if !bytes.Contains(exif, []byte("Camera")) {
+		t.Error("Expected the other EXIF tags to be kept")
+	}
+
+	// Rotating by 90 degrees swaps the pixel aspect ratio
+	swapped := makeChunk("pHYs", []byte{0, 0, 0x16, 0x24, 0, 0, 0x0B, 0x12, 1})
+	if !bytes.Contains(cleaned, swapped) {
+		t.Error("Expected pHYs resolutions to be swapped")
+	}
+
+	// The streaming reader holds the whole image and produces the same output
+	streamed, err := io.ReadAll(NewReaderWithOptions(bytes.NewReader(data), opts))
+	if err != nil {
+		t.Fatalf("Failed to read stripped PNG: %v", err)
+	}
+	if !bytes.Equal(streamed, cleaned) {
+		t.Error("Reader output differs from StripWithOptions output")
+	}
+}
+
+func TestApplyOrientationUnchanged(t *testing.T) {
+	opts := DefaultOptions()
+	opts.ApplyOrientation = true
+
+	tests := []struct {
+		name     string
+		data     []byte
+		warnings int
+	}{
+		{"No eXIf", buildICCPNG(t), 0},
+		{"Animated", func() []byte {
+			data := buildAPNG(t, 0)
+			exif := makeChunk("eXIf", exifPayload(binary.LittleEndian, 3))
+			return append(append(bytes.Clone(data[:33]), exif...), data[33:]...)
+		}(), 1},
+		{"Corrupt image data", buildPNG(
+			makeChunk("IHDR", encodedChunks(t, image.NewGray(image.Rect(0, 0, 4, 4)))["IHDR"]),
+			makeChunk("eXIf", exifPayload(binary.BigEndian, 6)),
+			makeChunk("IDAT", []byte("garbage")),
+			makeChunk("IEND", nil),
+		), 1},
+		{"Bit depth 0", buildPNG(
+			makeChunk("IHDR", []byte{0, 0, 0, 4, 0, 0, 0, 4, 0, 0, 0, 0, 0}),
+			makeChunk("eXIf", exifPayload(binary.BigEndian, 6)),
+			makeChunk("IDAT", encodedChunks(t, image.NewGray(image.Rect(0, 0, 4, 4)))["IDAT"]),
+			makeChunk("IEND", nil),
+		), 1},
+		{"Oversized", buildPNG(
+			makeChunk("IHDR", []byte{0x7F, 0xFF, 0xFF, 0xFF, 0x7F, 0xFF, 0xFF, 0xFF, 8, 6, 0, 0, 0}),
+			makeChunk("eXIf", exifPayload(binary.BigEndian, 6)),
+			makeChunk("IDAT", encodedChunks(t, image.NewGray(image.Rect(0, 0, 4, 4)))["IDAT"]),
+			makeChunk("IEND", nil),
+		), 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expected, _, err := Strip(tt.data)
+			if err != nil {
+				t.Fatalf("Failed to process PNG: %v", err)
+			}
+			cleaned, result, err := StripWithOptions(tt.data, opts)
+			if err != nil {
+				t.Fatalf("Failed to process PNG: %v", err)
+			}
+
+			if !bytes.Equal(cleaned, expected) || result.PixelTransform {
+				t.Error("Expected the image data to be left unchanged")
+			}
+			if len(result.Warnings) != tt.warnings {
+				t.Errorf("Expected %d warnings, got %v", tt.warnings, result.Warnings)
+			}
+		})
+	}
+}
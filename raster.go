@@ -0,0 +1,349 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+)
+
+// raster holds decoded image data at its original color type and bit depth.
+// Samples are unpacked: pixels of less than 8 bits take one byte each, all
+// others keep their big-endian byte layout.
+type raster struct {
+	width, height int
+	bitDepth      int
+	colorType     int
+	interlaced    bool   // Encoded with Adam7 interlacing
+	pix           []byte // width*height pixels of pixelSize bytes, row by row
+}
+
+// header returns the image header describing the raster
+func (r *raster) header() ImageHeader {
+	return ImageHeader{Width: r.width, Height: r.height, BitDepth: r.bitDepth, ColorType: r.colorType, Interlaced: r.interlaced}
+}
+
+// Samples per pixel for each color type
+var channels = map[int]int{0: 1, 2: 3, 3: 1, 4: 2, 6: 4}
+
+// pixelSize returns the number of bytes used by one unpacked pixel
+func (r *raster) pixelSize() int {
+	return max(1, channels[r.colorType]*r.bitDepth/8)
+}
+
+// Adam7 passes: starting column and row, column and row steps
+var adam7 = [7][4]int{
+	{0, 0, 8, 8},
+	{4, 0, 8, 8},
+	{0, 4, 4, 8},
+	{2, 0, 4, 4},
+	{0, 2, 2, 4},
+	{1, 0, 2, 2},
+	{0, 1, 1, 2},
+}
+
+// Largest number of pixels decoded, that of an 8192x8192 image.
+// Decoding and verifying such an image takes up to 2 GiB of memory.
+const maxPixels = 1 << 26
+
+// decodeRaster inflates and unfilters the concatenated IDAT data of an
+// image, undoing Adam7 interlacing. Images of more than maxPixels pixels
+// are rejected before anything is allocated.
+func decodeRaster(h ImageHeader, idat []byte) (*raster, error) {
+	r, _, err := readRaster(h, idat)
+	return r, err
+}
+
+// readRaster is decodeRaster also returning the zlib stream, positioned
+// after the last row
+func readRaster(h ImageHeader, idat []byte) (*raster, io.Reader, error) {
+	if err := validateHeader(h); err != nil {
+		return nil, nil, err
+	}
+	if h.Width > maxPixels/h.Height {
+		return nil, nil, fmt.Errorf("%w: %dx%d image exceeds %d pixels", ErrInvalidChunk, h.Width, h.Height, maxPixels)
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(idat))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidChunk, err)
+	}
+
+	r := &raster{width: h.Width, height: h.Height, bitDepth: h.BitDepth, colorType: h.ColorType, interlaced: h.Interlaced}
+	r.pix = make([]byte, r.width*r.height*r.pixelSize())
+
+	for _, p := range r.passes() {
+		w, rows := r.passSize(p)
+		if w <= 0 || rows <= 0 {
+			continue
+		}
+
+		line := make([]byte, 1+r.rowBytes(w))
+		prev := make([]byte, len(line)-1)
+		for i := 0; i < rows; i++ {
+			if _, err := io.ReadFull(zr, line); err != nil {
+				return nil, nil, fmt.Errorf("%w: image data too short", ErrInvalidChunk)
+			}
+			row := line[1:]
+			if err := unfilter(line[0], row, prev, r.filterOffset()); err != nil {
+				return nil, nil, err
+			}
+			r.unpackRow(row, p[1]+i*p[3], p[0], p[2], w)
+			copy(prev, row)
+		}
+	}
+
+	return r, zr, nil
+}
+
+// passes returns the starting column and row and the column and row steps
+// of the passes the raster is encoded in
+func (r *raster) passes() [][4]int {
+	if r.interlaced {
+		return adam7[:]
+	}
+	return [][4]int{{0, 0, 1, 1}}
+}
+
+// passSize returns the number of columns and rows of a pass
+func (r *raster) passSize(p [4]int) (int, int) {
+	return (r.width - p[0] + p[2] - 1) / p[2], (r.height - p[1] + p[3] - 1) / p[3]
+}
+
+// Filter strategy of encode choosing the filter of each row by heuristic,
+// rather than using the same filter type for every row
+const adaptiveFilter = -1
+
+// compress returns the smallest encoding of the raster among the adaptive
+// strategy and each fixed filter type, deflating the best of them again
+// with zlibOptimal when its filtered data is at most optimalLimit bytes
+func (r *raster) compress(optimalLimit int) ([]byte, error) {
+	var best, filtered []byte
+	for strategy := adaptiveFilter; strategy <= 4; strategy++ {
+		data := r.filter(strategy)
+		encoded, err := deflate(data)
+		if err != nil {
+			return nil, err
+		}
+		if best == nil || len(encoded) < len(best) {
+			best, filtered = encoded, data
+		}
+	}
+
+	if len(filtered) <= optimalLimit {
+		if encoded := zlibOptimal(filtered); len(encoded) < len(best) {
+			best = encoded
+		}
+	}
+	return best, nil
+}
+
+// encode filters and deflates the raster with maximum compression, using
+// the given filter type for every row or adaptiveFilter
+func (r *raster) encode(strategy int) ([]byte, error) {
+	return deflate(r.filter(strategy))
+}
+
+// filter returns the filtered rows of every pass of the raster, each
+// preceded by its filter type, using the given filter type for every row
+// or adaptiveFilter
+func (r *raster) filter(strategy int) []byte {
+	var out []byte
+	for _, p := range r.passes() {
+		w, rows := r.passSize(p)
+		if w <= 0 || rows <= 0 {
+			continue
+		}
+
+		rowBytes := r.rowBytes(w)
+		row := make([]byte, rowBytes)
+		prev := make([]byte, rowBytes)
+		filtered := make([]byte, 1+rowBytes)
+		for i := 0; i < rows; i++ {
+			r.packRow(row, p[1]+i*p[3], p[0], p[2], w)
+			r.filterRow(filtered, row, prev, strategy)
+			out = append(out, filtered...)
+			row, prev = prev, row
+		}
+	}
+	return out
+}
+
+// deflate compresses data to a zlib stream at the strongest level of
+// compress/zlib
+func deflate(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&buf, zlib.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// rowBytes returns the size of a packed row of w pixels, without the filter
+// type byte
+func (r *raster) rowBytes(w int) int {
+	return (w*channels[r.colorType]*r.bitDepth + 7) / 8
+}
+
+// filterOffset returns the distance in bytes between corresponding bytes of
+// adjacent pixels, as used by the filters
+func (r *raster) filterOffset() int {
+	return max(1, channels[r.colorType]*r.bitDepth/8)
+}
+
+// unpackRow stores a packed row of w pixels at row y, starting at column x0
+// and advancing dx columns per pixel
+func (r *raster) unpackRow(row []byte, y, x0, dx, w int) {
+	size := r.pixelSize()
+	base := y * r.width * size
+	if r.bitDepth >= 8 {
+		for i := 0; i < w; i++ {
+			copy(r.pix[base+(x0+i*dx)*size:], row[i*size:(i+1)*size])
+		}
+		return
+	}
+
+	perByte := 8 / r.bitDepth
+	mask := byte(1<<r.bitDepth - 1)
+	for i := 0; i < w; i++ {
+		shift := 8 - r.bitDepth*(i%perByte+1)
+		r.pix[base+x0+i*dx] = row[i/perByte] >> shift & mask
+	}
+}
+
+// packRow writes w pixels of row y in packed form, starting at column x0
+// and advancing dx columns per pixel
+func (r *raster) packRow(row []byte, y, x0, dx, w int) {
+	size := r.pixelSize()
+	base := y * r.width * size
+	if r.bitDepth >= 8 {
+		for i := 0; i < w; i++ {
+			copy(row[i*size:], r.pix[base+(x0+i*dx)*size:base+(x0+i*dx+1)*size])
+		}
+		return
+	}
+
+	clear(row)
+	perByte := 8 / r.bitDepth
+	for i := 0; i < w; i++ {
+		row[i/perByte] |= r.pix[base+x0+i*dx] << (8 - r.bitDepth*(i%perByte+1))
+	}
+}
+
+// unfilter reverses the filter of a scanline in place
+func unfilter(filter byte, row, prev []byte, bpp int) error {
+	switch filter {
+	case 0: // None
+	case 1: // Sub
+		for i := bpp; i < len(row); i++ {
+			row[i] += row[i-bpp]
+		}
+	case 2: // Up
+		for i := range row {
+			row[i] += prev[i]
+		}
+	case 3: // Average
+		for i := range row {
+			var left byte
+			if i >= bpp {
+				left = row[i-bpp]
+			}
+			row[i] += byte((int(left) + int(prev[i])) / 2)
+		}
+	case 4: // Paeth
+		for i := range row {
+			var left, upLeft byte
+			if i >= bpp {
+				left, upLeft = row[i-bpp], prev[i-bpp]
+			}
+			row[i] += paeth(left, prev[i], upLeft)
+		}
+	default:
+		return fmt.Errorf("%w: invalid filter type %d", ErrInvalidChunk, filter)
+	}
+	return nil
+}
+
+// filterRow writes the filter type and filtered bytes of row to dst. With
+// adaptiveFilter, indexed and low bit depth images are not filtered and
+// others use the filter with the smallest sum of absolute differences.
+func (r *raster) filterRow(dst, row, prev []byte, strategy int) {
+	if strategy != adaptiveFilter {
+		dst[0] = byte(strategy)
+		applyFilter(dst[0], dst[1:], row, prev, r.filterOffset())
+		return
+	}
+	if r.colorType == 3 || r.bitDepth < 8 {
+		dst[0] = 0
+		copy(dst[1:], row)
+		return
+	}
+
+	bpp := r.filterOffset()
+	best := make([]byte, len(dst))
+	bestSum := -1
+	for filter := byte(0); filter <= 4; filter++ {
+		applyFilter(filter, dst[1:], row, prev, bpp)
+		sum := 0
+		for _, b := range dst[1:] {
+			sum += min(int(b), 256-int(b))
+		}
+		if bestSum < 0 || sum < bestSum {
+			bestSum = sum
+			best[0] = filter
+			copy(best[1:], dst[1:])
+		}
+	}
+	copy(dst, best)
+}
+
+// applyFilter filters row with the given filter type into dst
+func applyFilter(filter byte, dst, row, prev []byte, bpp int) {
+	for i := range row {
+		var left, upLeft byte
+		if i >= bpp {
+			left, upLeft = row[i-bpp], prev[i-bpp]
+		}
+
+		switch filter {
+		case 0:
+			dst[i] = row[i]
+		case 1:
+			dst[i] = row[i] - left
+		case 2:
+			dst[i] = row[i] - prev[i]
+		case 3:
+			dst[i] = row[i] - byte((int(left)+int(prev[i]))/2)
+		case 4:
+			dst[i] = row[i] - paeth(left, prev[i], upLeft)
+		}
+	}
+}
+
+// paeth is the Paeth predictor from the PNG specification
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	default:
+		return c
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
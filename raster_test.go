@@ -0,0 +1,235 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// testImages returns small images covering the color types and bit depths
+// produced by image/png
+func testImages() map[string]image.Image {
+	rect := image.Rect(0, 0, 11, 7)
+	gray := image.NewGray(rect)
+	gray16 := image.NewGray16(rect)
+	nrgba := image.NewNRGBA(rect)
+	rgba64 := image.NewNRGBA64(rect)
+	palette := color.Palette{color.Black, color.White, color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}}
+	paletted := image.NewPaletted(rect, palette)
+	mono := image.NewPaletted(rect, palette[:2])
+
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			v := uint8(x*23 + y*41)
+			gray.SetGray(x, y, color.Gray{v})
+			gray16.SetGray16(x, y, color.Gray16{uint16(v)<<8 | uint16(x)})
+			nrgba.SetNRGBA(x, y, color.NRGBA{v, 255 - v, uint8(x), uint8(y * 30)})
+			rgba64.SetNRGBA64(x, y, color.NRGBA64{uint16(v) << 8, uint16(x), uint16(y), 0xFFFF})
+			paletted.SetColorIndex(x, y, uint8((x+y)%4))
+			mono.SetColorIndex(x, y, uint8((x*y)%2))
+		}
+	}
+
+	return map[string]image.Image{
+		"gray": gray, "gray16": gray16, "nrgba": nrgba,
+		"nrgba64": rgba64, "paletted": paletted, "mono": mono,
+	}
+}
+
+// assertSamePixels fails the test unless two images have identical pixels
+func assertSamePixels(t *testing.T, expected, actual image.Image) {
+	t.Helper()
+
+	if expected.Bounds().Size() != actual.Bounds().Size() {
+		t.Fatalf("Expected size %v, got %v", expected.Bounds().Size(), actual.Bounds().Size())
+	}
+	for y := 0; y < expected.Bounds().Dy(); y++ {
+		for x := 0; x < expected.Bounds().Dx(); x++ {
+			e := color.NRGBA64Model.Convert(expected.At(x, y))
+			a := color.NRGBA64Model.Convert(actual.At(x, y))
+			if e != a {
+				t.Fatalf("Pixel (%d, %d): expected %v, got %v", x, y, e, a)
+			}
+		}
+	}
+}
+
+func TestRasterRoundTrip(t *testing.T) {
+	for name, img := range testImages() {
+		t.Run(name, func(t *testing.T) {
+			encoded := encodedChunks(t, img)
+			r, err := decodeRaster(parseImageHeader(encoded["IHDR"]), encoded["IDAT"])
+			if err != nil {
+				t.Fatalf("Failed to decode raster: %v", err)
+			}
+			for strategy := adaptiveFilter; strategy <= 4; strategy++ {
+				idat, err := r.encode(strategy)
+				if err != nil {
+					t.Fatalf("Failed to encode raster: %v", err)
+				}
+
+				data := buildPNG(makeChunk("IHDR", encoded["IHDR"]), makeChunk("PLTE", encoded["PLTE"]),
+					makeChunk("IDAT", idat), makeChunk("IEND", nil))
+				if encoded["PLTE"] == nil {
+					data = buildPNG(makeChunk("IHDR", encoded["IHDR"]), makeChunk("IDAT", idat), makeChunk("IEND", nil))
+				}
+				decoded, err := png.Decode(bytes.NewReader(data))
+				if err != nil {
+					t.Fatalf("Failed to decode PNG re-encoded with strategy %d: %v", strategy, err)
+				}
+				assertSamePixels(t, img, decoded)
+			}
+		})
+	}
+}
+
+func TestRasterInterlaced(t *testing.T) {
+	for name, img := range testImages() {
+		t.Run(name, func(t *testing.T) {
+			encoded := encodedChunks(t, img)
+			h := parseImageHeader(encoded["IHDR"])
+			expected, err := decodeRaster(h, encoded["IDAT"])
+			if err != nil {
+				t.Fatalf("Failed to decode raster: %v", err)
+			}
+
+			h.Interlaced = true
+			r, err := decodeRaster(h, interlacedData(t, expected))
+			if err != nil {
+				t.Fatalf("Failed to decode interlaced raster: %v", err)
+			}
+			if !bytes.Equal(r.pix, expected.pix) {
+				t.Error("Interlaced image decoded differently")
+			}
+
+			idat, err := r.compress(0)
+			if err != nil {
+				t.Fatalf("Failed to encode interlaced raster: %v", err)
+			}
+			if r, err = decodeRaster(h, idat); err != nil {
+				t.Fatalf("Failed to decode re-encoded raster: %v", err)
+			}
+			if !bytes.Equal(r.pix, expected.pix) {
+				t.Error("Re-encoded interlaced image decoded differently")
+			}
+		})
+	}
+}
+
+func TestRasterCompress(t *testing.T) {
+	for name, img := range testImages() {
+		t.Run(name, func(t *testing.T) {
+			encoded := encodedChunks(t, img)
+			h := parseImageHeader(encoded["IHDR"])
+			r, err := decodeRaster(h, encoded["IDAT"])
+			if err != nil {
+				t.Fatalf("Failed to decode raster: %v", err)
+			}
+
+			idat, err := r.compress(1 << 20)
+			if err != nil {
+				t.Fatalf("Failed to compress raster: %v", err)
+			}
+			if err := verifyRaster(r, idat); err != nil {
+				t.Errorf("Compressed raster not verified: %v", err)
+			}
+			for strategy := adaptiveFilter; strategy <= 4; strategy++ {
+				standard, err := r.encode(strategy)
+				if err != nil {
+					t.Fatalf("Failed to encode raster: %v", err)
+				}
+				if len(idat) > len(standard) {
+					t.Errorf("Compressed to %d bytes, strategy %d gives %d", len(idat), strategy, len(standard))
+				}
+			}
+
+			decoded, err := decodeRaster(h, idat)
+			if err != nil {
+				t.Fatalf("Failed to decode compressed raster: %v", err)
+			}
+			if !bytes.Equal(decoded.pix, r.pix) {
+				t.Error("Compressed raster decoded differently")
+			}
+		})
+	}
+}
+
+func TestRasterErrors(t *testing.T) {
+	encoded := encodedChunks(t, image.NewGray(image.Rect(0, 0, 4, 4)))
+	h := parseImageHeader(encoded["IHDR"])
+
+	if _, err := decodeRaster(h, []byte("garbage")); err == nil {
+		t.Error("Expected an error for invalid zlib data")
+	}
+
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write([]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9})
+	zw.Close()
+	if _, err := decodeRaster(h, buf.Bytes()); err == nil {
+		t.Error("Expected an error for truncated image data")
+	}
+
+	buf.Reset()
+	zw = zlib.NewWriter(&buf)
+	zw.Write(bytes.Repeat([]byte{7, 0, 0, 0, 0}, 4))
+	zw.Close()
+	if _, err := decodeRaster(h, buf.Bytes()); err == nil {
+		t.Error("Expected an error for an invalid filter type")
+	}
+
+	// Invalid and oversized headers are rejected before allocating
+	for _, header := range []ImageHeader{
+		{Width: 4, Height: 4, BitDepth: 0, ColorType: 0},
+		{Width: 4, Height: 4, BitDepth: 8, ColorType: 5},
+		{Width: 1<<31 - 1, Height: 1<<31 - 1, BitDepth: 8, ColorType: 6},
+		{Width: 100000, Height: 100000, BitDepth: 8, ColorType: 6},
+	} {
+		if _, err := decodeRaster(header, buf.Bytes()); !errors.Is(err, ErrInvalidChunk) {
+			t.Errorf("Expected ErrInvalidChunk for %+v, got %v", header, err)
+		}
+	}
+}
+
+// interlacedData encodes a raster as Adam7 interlaced, unfiltered IDAT data
+func interlacedData(t *testing.T, r *raster) []byte {
+	t.Helper()
+
+	var raw []byte
+	for _, p := range adam7 {
+		w := (r.width - p[0] + p[2] - 1) / p[2]
+		rows := (r.height - p[1] + p[3] - 1) / p[3]
+		if w <= 0 || rows <= 0 {
+			continue
+		}
+
+		pass := &raster{width: w, height: rows, bitDepth: r.bitDepth, colorType: r.colorType}
+		size := r.pixelSize()
+		for y := p[1]; y < r.height; y += p[3] {
+			for x := p[0]; x < r.width; x += p[2] {
+				pass.pix = append(pass.pix, r.pix[(y*r.width+x)*size:(y*r.width+x+1)*size]...)
+			}
+		}
+
+		row := make([]byte, pass.rowBytes(w))
+		for y := 0; y < rows; y++ {
+			pass.packRow(row, y, 0, 1, w)
+			raw = append(raw, 0)
+			raw = append(raw, row...)
+		}
+	}
+
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(raw); err != nil {
+		t.Fatalf("Failed to compress image data: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to compress image data: %v", err)
+	}
+	return buf.Bytes()
+}
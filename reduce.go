@@ -0,0 +1,582 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"slices"
+)
+
+// reducesColor reports whether opts may change the color type, bit depth
+// or palette of the image
+func reducesColor(opts Options) bool {
+	return opts.ReduceColor || opts.OptimizePalette
+}
+
+// colorTarget is a representation of an image's colors: a color type and
+// bit depth, with the palette of indexed images or the transparent color of
+// grayscale and truecolor images keyed by tRNS
+type colorTarget struct {
+	colorType int
+	bitDepth  int
+	palette   [][4]uint16 // PLTE and tRNS entries for indexed color
+	key       *[4]uint16  // Color made transparent by tRNS, nil if none
+}
+
+func (t colorTarget) bitsPerPixel() int {
+	return channels[t.colorType] * t.bitDepth
+}
+
+// reduceColors re-encodes the raster in the smallest lossless color type
+// and bit depth allowed by Options.ReduceColor and OptimizePalette and the
+// kept color chunks, and updates PLTE, tRNS and the chunks that depend on
+// them. The held chunks and raster are returned unchanged when no smaller
+// representation is found.
+func (s *stripper) reduceColors(held []heldChunk, r *raster, offset int) ([]heldChunk, *raster) {
+	chunks := map[string][]byte{}
+	for _, c := range held {
+		chunkType := c.chunkType()
+		if _, ok := chunks[chunkType]; !ok {
+			chunks[chunkType] = c.data[8 : len(c.data)-4]
+		}
+	}
+
+	if chunks["acTL"] != nil {
+		s.warn("acTL", offset, "colors not reduced in animated image")
+		return held, r
+	}
+	// A suggested palette of a truecolor image would lose its meaning
+	if chunks["PLTE"] != nil && r.colorType != 3 {
+		return held, r
+	}
+
+	pix, err := r.rgba(chunks["PLTE"], chunks["tRNS"])
+	if err != nil {
+		s.warn("IDAT", offset, "colors not reduced: %v", err)
+		return held, r
+	}
+
+	// An ICC profile describes either grayscale or RGB data
+	gray, rgb := true, true
+	if chunks["iCCP"] != nil {
+		if iccp, err := parseICCP(chunks["iCCP"]); err == nil && len(iccp.profile) >= 20 {
+			space := string(iccp.profile[16:20])
+			gray, rgb = space == "GRAY", space == "RGB "
+		}
+	}
+
+	source := r.header()
+	for _, t := range colorTargets(pix, s.policy.opts, source, chunks["PLTE"], gray, rgb) {
+		if t.colorType == source.ColorType && t.bitDepth == source.BitDepth && t.colorType != 3 {
+			break // Already in the smallest representation
+		}
+
+		reduced := t.convert(r, pix)
+		plte, trns := t.paletteData(), t.transparencyData()
+		if t.colorType == 3 && bytes.Equal(plte, chunks["PLTE"]) && bytes.Equal(trns, chunks["tRNS"]) && t.bitDepth == source.BitDepth {
+			break // Palette already optimal
+		}
+
+		converted, ok := t.convertChunks(held, chunks, source, plte, trns)
+		if !ok {
+			continue
+		}
+		if check, err := reduced.rgba(plte, trns); err != nil || !slices.Equal(check, pix) {
+			s.warn("IDAT", offset, "colors not reduced: reduced pixels differ")
+			break
+		}
+		return converted, reduced
+	}
+	return held, r
+}
+
+// pixelStats summarizes the colors of an image to find the color types
+// that represent it exactly
+type pixelStats struct {
+	opaque      bool // Every pixel is opaque
+	alphaBinary bool // Every pixel is opaque or fully transparent
+	gray        bool // Every pixel is gray
+	eightBit    bool // Every sample is exact in 8 bits
+	grayDepth   int  // Smallest gray bit depth holding every value
+
+	key   *[4]uint16 // Color of the fully transparent pixels, if they all share one
+	keyed bool       // Fully transparent pixels share one color so far
+
+	seen    map[[4]uint16]bool // Distinct colors, nil once there are more than 256
+	palette [][4]uint16        // Distinct colors in order of appearance
+}
+
+// newPixelStats collects the statistics of pix
+func newPixelStats(pix [][4]uint16) *pixelStats {
+	st := &pixelStats{
+		opaque: true, alphaBinary: true, gray: true, eightBit: true, grayDepth: 1,
+		keyed: true,
+		seen:  map[[4]uint16]bool{},
+	}
+	for _, p := range pix {
+		st.add(p)
+	}
+	if !st.eightBit {
+		st.grayDepth = 16
+	}
+
+	// A color key needs binary transparency and a color no opaque pixel has
+	if !st.alphaBinary || !st.keyed {
+		st.key = nil
+	}
+	if st.key != nil {
+		for _, p := range pix {
+			if p[3] != 0 && [3]uint16(p[:3]) == [3]uint16(st.key[:3]) {
+				st.key = nil
+				break
+			}
+		}
+	}
+	return st
+}
+
+// add updates the statistics with a pixel
+func (st *pixelStats) add(p [4]uint16) {
+	if p[3] != 0xFFFF {
+		st.opaque = false
+	}
+	if p[3] != 0 && p[3] != 0xFFFF {
+		st.alphaBinary = false
+	}
+	if p[0] != p[1] || p[1] != p[2] {
+		st.gray = false
+	}
+	for _, v := range p {
+		if v%257 != 0 {
+			st.eightBit = false
+		}
+	}
+	if st.gray && st.eightBit {
+		st.grayDepth = max(st.grayDepth, sampleDepth(uint8(p[0]>>8)))
+	}
+
+	if p[3] == 0 {
+		if st.key == nil {
+			st.key = &[4]uint16{p[0], p[1], p[2], 0}
+		} else if [3]uint16(st.key[:3]) != [3]uint16(p[:3]) {
+			st.keyed = false
+		}
+	}
+
+	if st.seen != nil && !st.seen[p] {
+		if len(st.seen) == 256 {
+			st.seen = nil
+			return
+		}
+		st.seen[p] = true
+		st.palette = append(st.palette, p)
+	}
+}
+
+// depth returns the bit depth of non-indexed targets
+func (st *pixelStats) depth() int {
+	if st.eightBit {
+		return 8
+	}
+	return 16
+}
+
+// colorTargets returns the lossless representations of pix allowed by
+// opts, smallest first. gray and rgb tell which kinds of color type the
+// kept color chunks allow.
+func colorTargets(pix [][4]uint16, opts Options, source ImageHeader, plte []byte, gray, rgb bool) []colorTarget {
+	st := newPixelStats(pix)
+
+	var targets []colorTarget
+	if opts.ReduceColor && st.gray && gray {
+		if st.opaque || st.key != nil {
+			targets = append(targets, colorTarget{colorType: 0, bitDepth: st.grayDepth, key: st.key})
+		}
+		targets = append(targets, colorTarget{colorType: 4, bitDepth: st.depth()})
+	}
+	if st.seen != nil && st.eightBit && rgb && (opts.ReduceColor || source.ColorType == 3) {
+		targets = append(targets, colorTarget{
+			colorType: 3,
+			bitDepth:  sampleDepthForCount(len(st.palette)),
+			palette:   orderPalette(st.palette, source, plte),
+		})
+	}
+	if opts.ReduceColor && rgb {
+		if st.opaque || st.key != nil {
+			targets = append(targets, colorTarget{colorType: 2, bitDepth: st.depth(), key: st.key})
+		}
+		targets = append(targets, colorTarget{colorType: 6, bitDepth: st.depth()})
+	}
+
+	slices.SortStableFunc(targets, func(a, b colorTarget) int { return a.bitsPerPixel() - b.bitsPerPixel() })
+	return targets
+}
+
+// sampleDepth returns the smallest bit depth holding an 8-bit gray value
+// exactly
+func sampleDepth(v uint8) int {
+	switch {
+	case v%255 == 0:
+		return 1
+	case v%85 == 0:
+		return 2
+	case v%17 == 0:
+		return 4
+	default:
+		return 8
+	}
+}
+
+// sampleDepthForCount returns the smallest bit depth indexing n colors
+func sampleDepthForCount(n int) int {
+	switch {
+	case n <= 2:
+		return 1
+	case n <= 4:
+		return 2
+	case n <= 16:
+		return 4
+	default:
+		return 8
+	}
+}
+
+// orderPalette puts transparent entries first, so that tRNS can be as
+// short as possible. Colors of an indexed source keep their original order
+// otherwise, and others appear in the order they are first used.
+func orderPalette(palette [][4]uint16, source ImageHeader, plte []byte) [][4]uint16 {
+	if source.ColorType == 3 {
+		index := map[[3]uint16]int{}
+		for i := len(plte)/3 - 1; i >= 0; i-- {
+			index[[3]uint16{uint16(plte[3*i]) * 257, uint16(plte[3*i+1]) * 257, uint16(plte[3*i+2]) * 257}] = i
+		}
+		slices.SortStableFunc(palette, func(a, b [4]uint16) int {
+			return index[[3]uint16(a[:3])] - index[[3]uint16(b[:3])]
+		})
+	}
+	slices.SortStableFunc(palette, func(a, b [4]uint16) int {
+		return boolInt(a[3] == 0xFFFF) - boolInt(b[3] == 0xFFFF)
+	})
+	return palette
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// scaleSample converts a 16-bit sample to the given bit depth, which must
+// hold it exactly
+func scaleSample(v uint16, depth int) uint16 {
+	return v / (0xFFFF / (1<<depth - 1))
+}
+
+// convert returns the raster of pix in the target representation
+func (t colorTarget) convert(r *raster, pix [][4]uint16) *raster {
+	dst := &raster{width: r.width, height: r.height, bitDepth: t.bitDepth, colorType: t.colorType, interlaced: r.interlaced}
+	size := dst.pixelSize()
+	dst.pix = make([]byte, len(pix)*size)
+
+	index := make(map[[4]uint16]byte, len(t.palette))
+	for i, p := range t.palette {
+		index[p] = byte(i)
+	}
+
+	var samples []uint16
+	for i, p := range pix {
+		switch t.colorType {
+		case 0:
+			samples = append(samples[:0], p[0])
+		case 2:
+			samples = append(samples[:0], p[0], p[1], p[2])
+		case 3:
+			dst.pix[i] = index[p]
+			continue
+		case 4:
+			samples = append(samples[:0], p[0], p[3])
+		case 6:
+			samples = append(samples[:0], p[0], p[1], p[2], p[3])
+		}
+
+		out := dst.pix[i*size : (i+1)*size]
+		for j, v := range samples {
+			switch {
+			case t.bitDepth == 16:
+				binary.BigEndian.PutUint16(out[2*j:], v)
+			default:
+				out[j] = byte(scaleSample(v, t.bitDepth))
+			}
+		}
+	}
+	return dst
+}
+
+// paletteData returns the PLTE data of an indexed target, nil otherwise
+func (t colorTarget) paletteData() []byte {
+	var plte []byte
+	for _, p := range t.palette {
+		plte = append(plte, byte(p[0]>>8), byte(p[1]>>8), byte(p[2]>>8))
+	}
+	return plte
+}
+
+// transparencyData returns the tRNS data of the target, nil if it needs
+// none. Trailing opaque palette entries are omitted.
+func (t colorTarget) transparencyData() []byte {
+	if t.colorType == 3 {
+		n := 0
+		for i, p := range t.palette {
+			if p[3] != 0xFFFF {
+				n = i + 1
+			}
+		}
+		if n == 0 {
+			return nil
+		}
+		trns := make([]byte, n)
+		for i := range trns {
+			trns[i] = byte(t.palette[i][3] >> 8)
+		}
+		return trns
+	}
+	if t.key == nil {
+		return nil
+	}
+
+	samples := t.key[:3]
+	if t.colorType == 0 {
+		samples = t.key[:1]
+	}
+	var trns []byte
+	for _, v := range samples {
+		if t.bitDepth < 16 {
+			v = scaleSample(v, t.bitDepth)
+		}
+		trns = binary.BigEndian.AppendUint16(trns, v)
+	}
+	return trns
+}
+
+// convertChunks returns the held chunks with PLTE and tRNS replaced for the
+// target, and sBIT, bKGD and hIST converted. It reports false when one of
+// them cannot be expressed in the target representation.
+func (t colorTarget) convertChunks(held []heldChunk, chunks map[string][]byte, source ImageHeader, plte, trns []byte) ([]heldChunk, bool) {
+	var converted []heldChunk
+	for _, c := range held {
+		chunkType := c.chunkType()
+		data := c.data[8 : len(c.data)-4]
+
+		// PLTE precedes tRNS, bKGD, hIST and IDAT, and tRNS precedes IDAT
+		if plte != nil && (chunkType == "tRNS" || chunkType == "bKGD" || chunkType == "hIST" || chunkType == "IDAT") {
+			converted = append(converted, heldChunk{info: -1, data: buildChunk("PLTE", plte)})
+			plte = nil
+		}
+		if trns != nil && chunkType == "IDAT" {
+			converted = append(converted, heldChunk{info: -1, data: buildChunk("tRNS", trns)})
+			trns = nil
+		}
+
+		switch chunkType {
+		case "PLTE":
+			continue
+		case "tRNS":
+			if trns != nil {
+				c.data = buildChunk("tRNS", trns)
+				trns = nil
+			} else {
+				continue
+			}
+		case "sBIT":
+			bits, ok := t.significantBits(data, source)
+			if !ok {
+				return nil, false
+			}
+			c.data = buildChunk("sBIT", bits)
+		case "bKGD":
+			background, ok := t.background(data, source, chunks["PLTE"])
+			if !ok {
+				return nil, false
+			}
+			c.data = buildChunk("bKGD", background)
+		case "hIST":
+			if t.colorType != 3 {
+				return nil, false
+			}
+			c.data = buildChunk("hIST", t.histogram(data, chunks["PLTE"], chunks["tRNS"]))
+		}
+		converted = append(converted, c)
+	}
+	return converted, true
+}
+
+// significantBits converts sBIT data to the channels of the target, using
+// the largest value of the color channels for grayscale. It reports false
+// for malformed data.
+func (t colorTarget) significantBits(data []byte, source ImageHeader) ([]byte, bool) {
+	var rgb []byte
+	alpha := byte(t.bitDepth) // All bits are significant in added alpha
+	switch {
+	case source.ColorType == 0 && len(data) == 1:
+		rgb = []byte{data[0], data[0], data[0]}
+	case (source.ColorType == 2 || source.ColorType == 3) && len(data) == 3:
+		rgb = data
+	case source.ColorType == 4 && len(data) == 2:
+		rgb, alpha = []byte{data[0], data[0], data[0]}, data[1]
+	case source.ColorType == 6 && len(data) == 4:
+		rgb, alpha = data[:3], data[3]
+	default:
+		return nil, false
+	}
+
+	var out []byte
+	switch t.colorType {
+	case 0:
+		out = []byte{slices.Max(rgb)}
+	case 2, 3:
+		out = slices.Clone(rgb)
+	case 4:
+		out = []byte{slices.Max(rgb), alpha}
+	case 6:
+		out = append(slices.Clone(rgb), alpha)
+	}
+
+	// Values may not exceed the sample depth, which is 8 for palettes
+	limit := byte(t.bitDepth)
+	if t.colorType == 3 {
+		limit = 8
+	}
+	for i := range out {
+		out[i] = min(max(out[i], 1), limit)
+	}
+	return out, true
+}
+
+// background converts bKGD data to the target, reporting false when the
+// color cannot be represented
+func (t colorTarget) background(data []byte, source ImageHeader, plte []byte) ([]byte, bool) {
+	var c [3]uint16
+	switch {
+	case source.ColorType == 3 && len(data) == 1 && 3*int(data[0])+3 <= len(plte):
+		i := int(data[0])
+		c = [3]uint16{uint16(plte[3*i]) * 257, uint16(plte[3*i+1]) * 257, uint16(plte[3*i+2]) * 257}
+	case (source.ColorType == 0 || source.ColorType == 4) && len(data) == 2:
+		v := scaleUp(binary.BigEndian.Uint16(data), source.BitDepth)
+		c = [3]uint16{v, v, v}
+	case (source.ColorType == 2 || source.ColorType == 6) && len(data) == 6:
+		for i := range c {
+			c[i] = scaleUp(binary.BigEndian.Uint16(data[2*i:]), source.BitDepth)
+		}
+	default:
+		return nil, false
+	}
+
+	switch t.colorType {
+	case 3:
+		for i, p := range t.palette {
+			if [3]uint16(p[:3]) == c {
+				return []byte{byte(i)}, true
+			}
+		}
+		return nil, false
+	case 0, 4:
+		if c[0] != c[1] || c[1] != c[2] || !representable(c[0], t.bitDepth) {
+			return nil, false
+		}
+		return binary.BigEndian.AppendUint16(nil, scaleSample(c[0], t.bitDepth)), true
+	default:
+		var out []byte
+		for _, v := range c {
+			if !representable(v, t.bitDepth) {
+				return nil, false
+			}
+			out = binary.BigEndian.AppendUint16(out, scaleSample(v, t.bitDepth))
+		}
+		return out, true
+	}
+}
+
+// histogram maps hIST data of the source palette to the target palette
+func (t colorTarget) histogram(data, plte, trns []byte) []byte {
+	counts := make([]uint32, len(t.palette))
+	for i := 0; 2*i+2 <= len(data) && 3*i+3 <= len(plte); i++ {
+		p := paletteColor(plte, trns, i)
+		if j := slices.Index(t.palette, p); j >= 0 {
+			counts[j] += uint32(binary.BigEndian.Uint16(data[2*i:]))
+		}
+	}
+
+	out := make([]byte, 0, 2*len(counts))
+	for _, n := range counts {
+		out = binary.BigEndian.AppendUint16(out, uint16(min(n, 0xFFFF)))
+	}
+	return out
+}
+
+// representable reports whether a 16-bit sample can be stored exactly at
+// the given bit depth
+func representable(v uint16, depth int) bool {
+	return depth == 16 || v%(0xFFFF/(1<<depth-1)) == 0
+}
+
+// scaleUp converts a sample of the given bit depth to 16 bits
+func scaleUp(v uint16, depth int) uint16 {
+	if depth == 16 {
+		return v
+	}
+	return v * (0xFFFF / (1<<depth - 1))
+}
+
+// paletteColor returns entry i of a palette as 16-bit RGBA
+func paletteColor(plte, trns []byte, i int) [4]uint16 {
+	alpha := uint16(0xFFFF)
+	if i < len(trns) {
+		alpha = uint16(trns[i]) * 257
+	}
+	return [4]uint16{uint16(plte[3*i]) * 257, uint16(plte[3*i+1]) * 257, uint16(plte[3*i+2]) * 257, alpha}
+}
+
+// rgba returns the pixels of the raster as 16-bit non-premultiplied RGBA,
+// with the transparency of tRNS applied
+func (r *raster) rgba(plte, trns []byte) ([][4]uint16, error) {
+	size := r.pixelSize()
+	sample := func(p []byte, i int) uint16 {
+		if r.bitDepth == 16 {
+			return binary.BigEndian.Uint16(p[2*i:])
+		}
+		return uint16(p[i])
+	}
+
+	pix := make([][4]uint16, r.width*r.height)
+	for i := range pix {
+		p := r.pix[i*size : (i+1)*size]
+		switch r.colorType {
+		case 0:
+			v := scaleUp(sample(p, 0), r.bitDepth)
+			pix[i] = [4]uint16{v, v, v, 0xFFFF}
+			if len(trns) == 2 && sample(p, 0) == binary.BigEndian.Uint16(trns) {
+				pix[i][3] = 0
+			}
+		case 2:
+			pix[i] = [4]uint16{scaleUp(sample(p, 0), r.bitDepth), scaleUp(sample(p, 1), r.bitDepth), scaleUp(sample(p, 2), r.bitDepth), 0xFFFF}
+			if len(trns) == 6 && sample(p, 0) == binary.BigEndian.Uint16(trns) &&
+				sample(p, 1) == binary.BigEndian.Uint16(trns[2:]) && sample(p, 2) == binary.BigEndian.Uint16(trns[4:]) {
+				pix[i][3] = 0
+			}
+		case 3:
+			if 3*int(p[0])+3 > len(plte) {
+				return nil, fmt.Errorf("%w: palette index %d out of range", ErrInvalidChunk, p[0])
+			}
+			pix[i] = paletteColor(plte, trns, int(p[0]))
+		case 4:
+			v := scaleUp(sample(p, 0), r.bitDepth)
+			pix[i] = [4]uint16{v, v, v, scaleUp(sample(p, 1), r.bitDepth)}
+		case 6:
+			for j := range pix[i] {
+				pix[i][j] = scaleUp(sample(p, j), r.bitDepth)
+			}
+		}
+	}
+	return pix, nil
+}
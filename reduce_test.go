@@ -0,0 +1,261 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// reductionPNG encodes img as 8-bit RGBA, or 16-bit for NRGBA64, adding
+// extra chunks before IDAT. Paletted images are encoded by image/png.
+func reductionPNG(t *testing.T, img image.Image, extra ...[]byte) []byte {
+	t.Helper()
+
+	encoded := encodedChunks(t, img)
+	if _, ok := img.(*image.Paletted); !ok {
+		r := &raster{width: img.Bounds().Dx(), height: img.Bounds().Dy(), bitDepth: 8, colorType: 6}
+		for y := 0; y < r.height; y++ {
+			for x := 0; x < r.width; x++ {
+				switch img := img.(type) {
+				case *image.NRGBA:
+					c := img.NRGBAAt(x, y)
+					r.pix = append(r.pix, c.R, c.G, c.B, c.A)
+				case *image.NRGBA64:
+					c := img.NRGBA64At(x, y)
+					r.bitDepth = 16
+					for _, v := range []uint16{c.R, c.G, c.B, c.A} {
+						r.pix = binary.BigEndian.AppendUint16(r.pix, v)
+					}
+				}
+			}
+		}
+
+		idat, err := r.encode(adaptiveFilter)
+		if err != nil {
+			t.Fatalf("Failed to encode test image: %v", err)
+		}
+		header := binary.BigEndian.AppendUint32(nil, uint32(r.width))
+		header = binary.BigEndian.AppendUint32(header, uint32(r.height))
+		encoded = map[string][]byte{"IHDR": append(header, byte(r.bitDepth), 6, 0, 0, 0), "IDAT": idat}
+	}
+
+	chunks := [][]byte{makeChunk("IHDR", encoded["IHDR"])}
+	if encoded["PLTE"] != nil {
+		chunks = append(chunks, makeChunk("PLTE", encoded["PLTE"]))
+	}
+	if encoded["tRNS"] != nil {
+		chunks = append(chunks, makeChunk("tRNS", encoded["tRNS"]))
+	}
+	chunks = append(chunks, extra...)
+	chunks = append(chunks, makeChunk("IDAT", encoded["IDAT"]), makeChunk("IEND", nil))
+	return buildPNG(chunks...)
+}
+
+// filledNRGBA returns a 64x64 image with the color of each pixel given by c
+func filledNRGBA(c func(x, y int) color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.SetNRGBA(x, y, c(x, y))
+		}
+	}
+	return img
+}
+
+// noise returns a pseudo-random value below n for a pixel
+func noise(x, y, n int) int {
+	return (x*7919 + y*104729 + x*y*31) % 1013 % n
+}
+
+func TestReduceColor(t *testing.T) {
+	many := filledNRGBA(func(x, y int) color.NRGBA {
+		return color.NRGBA{uint8(x * 4), uint8(y * 4), uint8(noise(x, y, 256)), 255}
+	})
+	wide := image.NewNRGBA64(many.Bounds())
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			c := many.NRGBAAt(x, y)
+			wide.SetNRGBA64(x, y, color.NRGBA64{uint16(c.R) * 257, uint16(c.G) * 257, 1028, 0xFFFF})
+		}
+	}
+
+	tests := []struct {
+		name      string
+		img       image.Image
+		colorType int
+		bitDepth  int
+		trns      []byte
+	}{
+		{"gray levels", filledNRGBA(func(x, y int) color.NRGBA {
+			v := uint8(noise(x, y, 16) * 17)
+			return color.NRGBA{v, v, v, 255}
+		}), 0, 4, nil},
+		{"black and white", filledNRGBA(func(x, y int) color.NRGBA {
+			v := uint8(noise(x, y, 2) * 255)
+			return color.NRGBA{v, v, v, 255}
+		}), 0, 1, nil},
+		{"gray with alpha", filledNRGBA(func(x, y int) color.NRGBA {
+			v := uint8(noise(x, y, 256))
+			return color.NRGBA{v, v, v, uint8(64 + y%4*50)}
+		}), 4, 8, nil},
+		{"few colors", filledNRGBA(func(x, y int) color.NRGBA {
+			return []color.NRGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}}[noise(x, y, 3)]
+		}), 3, 2, nil},
+		{"few colors with transparency", filledNRGBA(func(x, y int) color.NRGBA {
+			return []color.NRGBA{{255, 0, 0, 255}, {0, 0, 0, 0}, {0, 0, 255, 128}}[noise(x, y, 3)]
+		}), 3, 2, []byte{0, 128}},
+		{"opaque", many, 2, 8, nil},
+		{"color key", filledNRGBA(func(x, y int) color.NRGBA {
+			if x == y {
+				return color.NRGBA{1, 2, 3, 0}
+			}
+			return many.NRGBAAt(x, y)
+		}), 2, 8, []byte{0, 1, 0, 2, 0, 3}},
+		{"redundant low bytes", wide, 2, 8, nil},
+	}
+
+	opts := DefaultOptions()
+	opts.ReduceColor = true
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := reductionPNG(t, tt.img)
+			cleaned, result, err := StripWithOptions(data, opts)
+			if err != nil {
+				t.Fatalf("Failed to process PNG: %v", err)
+			}
+
+			chunks := encodedChunksFromPNG(cleaned)
+			h := parseImageHeader(chunks["IHDR"])
+			if h.ColorType != tt.colorType || h.BitDepth != tt.bitDepth {
+				t.Errorf("Expected color type %d at %d bits, got %d at %d bits", tt.colorType, tt.bitDepth, h.ColorType, h.BitDepth)
+			}
+			if !bytes.Equal(chunks["tRNS"], tt.trns) {
+				t.Errorf("Expected tRNS %v, got %v", tt.trns, chunks["tRNS"])
+			}
+
+			decoded, err := png.Decode(bytes.NewReader(cleaned))
+			if err != nil {
+				t.Fatalf("Failed to decode reduced PNG: %v", err)
+			}
+			assertSamePixels(t, tt.img, decoded)
+
+			if len(cleaned) >= len(data) || result.Optimized.ImageData != len(data)-len(cleaned) {
+				t.Errorf("Expected %d bytes of image data savings, got %d", len(data)-len(cleaned), result.Optimized.ImageData)
+			}
+		})
+	}
+}
+
+func TestReduceColorKeepsChunks(t *testing.T) {
+	gray := filledNRGBA(func(x, y int) color.NRGBA {
+		v := uint8(noise(x, y, 16) * 17)
+		return color.NRGBA{v, v, v, 255}
+	})
+
+	opts := DefaultOptions()
+	opts.ReduceColor = true
+	opts.Keep = []string{"bKGD", "sBIT"}
+	opts.ReplaceSRGBProfile, opts.MinimizeProfile = false, false
+
+	// Without a profile, background and significant bits become grayscale
+	data := reductionPNG(t, gray,
+		makeChunk("sBIT", []byte{5, 6, 5, 8}),
+		makeChunk("bKGD", []byte{0, 0x88, 0, 0x88, 0, 0x88}),
+	)
+	cleaned, _, err := StripWithOptions(data, opts)
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+	chunks := encodedChunksFromPNG(cleaned)
+	if h := parseImageHeader(chunks["IHDR"]); h.ColorType != 0 || h.BitDepth != 4 {
+		t.Errorf("Expected 4-bit grayscale, got color type %d at %d bits", h.ColorType, h.BitDepth)
+	}
+	if !bytes.Equal(chunks["sBIT"], []byte{4}) || !bytes.Equal(chunks["bKGD"], []byte{0, 8}) {
+		t.Errorf("Unexpected sBIT %v and bKGD %v", chunks["sBIT"], chunks["bKGD"])
+	}
+
+	// An RGB profile rules out grayscale, leaving the palette
+	iccp := makeChunk("iCCP", iccpData(t, "Display P3", loadTestProfile(t)))
+	cleaned, _, err = StripWithOptions(reductionPNG(t, gray, iccp), opts)
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+	chunks = encodedChunksFromPNG(cleaned)
+	if h := parseImageHeader(chunks["IHDR"]); h.ColorType != 3 {
+		t.Errorf("Expected indexed color with an RGB profile, got color type %d", h.ColorType)
+	}
+	if types := chunkTypes(cleaned); types[1] != "iCCP" || types[2] != "PLTE" {
+		t.Errorf("Unexpected chunk order %v", types)
+	}
+	decoded, err := png.Decode(bytes.NewReader(cleaned))
+	if err != nil {
+		t.Fatalf("Failed to decode reduced PNG: %v", err)
+	}
+	assertSamePixels(t, gray, decoded)
+}
+
+func TestOptimizePalette(t *testing.T) {
+	palette := color.Palette{
+		color.NRGBA{255, 0, 0, 255},
+		color.NRGBA{0, 255, 0, 255}, // Unused
+		color.NRGBA{0, 0, 255, 0},
+		color.NRGBA{255, 255, 0, 255},
+		color.NRGBA{255, 0, 0, 255}, // Duplicate of the first entry
+		color.NRGBA{0, 255, 255, 64},
+		color.NRGBA{0, 0, 0, 255}, // Unused
+	}
+	img := image.NewPaletted(image.Rect(0, 0, 8, 8), palette)
+	for i := range img.Pix {
+		img.Pix[i] = []uint8{0, 2, 3, 4, 5}[i%5]
+	}
+
+	hist := make([]byte, 0, 2*len(palette))
+	for i := range palette {
+		hist = binary.BigEndian.AppendUint16(hist, uint16(i+1))
+	}
+
+	opts := DefaultOptions()
+	opts.OptimizePalette = true
+	opts.Keep = []string{"hIST"}
+	data := reductionPNG(t, img, makeChunk("hIST", hist))
+	cleaned, result, err := StripWithOptions(data, opts)
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+
+	chunks := encodedChunksFromPNG(cleaned)
+	expected := map[string][]byte{
+		"PLTE": {0, 0, 255, 0, 255, 255, 255, 0, 0, 255, 255, 0},
+		"tRNS": {0, 64},
+		"hIST": {0, 3, 0, 6, 0, 1 + 5, 0, 4},
+	}
+	for chunkType, data := range expected {
+		if !bytes.Equal(chunks[chunkType], data) {
+			t.Errorf("Expected %s %v, got %v", chunkType, data, chunks[chunkType])
+		}
+	}
+	if h := parseImageHeader(chunks["IHDR"]); h.BitDepth != 2 {
+		t.Errorf("Expected a bit depth of 2, got %d", h.BitDepth)
+	}
+
+	decoded, err := png.Decode(bytes.NewReader(cleaned))
+	if err != nil {
+		t.Fatalf("Failed to decode optimized PNG: %v", err)
+	}
+	assertSamePixels(t, img, decoded)
+	if result.Total != len(data)-len(cleaned) {
+		t.Errorf("Total is %d, expected %d", result.Total, len(data)-len(cleaned))
+	}
+
+	// An optimal palette is left alone
+	again, _, err := StripWithOptions(cleaned, opts)
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+	if !bytes.Equal(again, cleaned) {
+		t.Error("Optimized palette rewritten")
+	}
+}
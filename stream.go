@@ -0,0 +1,262 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Largest chunk length allowed by the PNG specification (2^31-1)
+const maxChunkLength = 1<<31 - 1
+
+// Reader strips metadata from a PNG stream while it is being read. Only one
+// chunk of the input is held in memory at a time, except for the chunks held
+// by options that rewrite the header or the image data.
+type Reader struct {
+	src     io.Reader
+	s       *stripper
+	out     bytes.Buffer // Stripped output not yet returned by Read
+	chunk   bytes.Buffer // Reused buffer for the current input chunk
+	offset  int          // Offset of the next input chunk
+	started bool         // PNG signature has been consumed
+	err     error        // Sticky error, io.EOF once the input is exhausted
+}
+
+// NewReader returns a reader that yields r's PNG data with unnecessary
+// metadata removed
+func NewReader(r io.Reader) *Reader {
+	return NewReaderWithOptions(r, DefaultOptions())
+}
+
+// NewReaderWithOptions returns a reader that yields r's PNG data with the
+// chunks rejected by opts removed
+func NewReaderWithOptions(r io.Reader, opts Options) *Reader {
+	sr := &Reader{src: r}
+	sr.s = newStripper(&sr.out, opts)
+	return sr
+}
+
+// Read implements io.Reader
+func (r *Reader) Read(p []byte) (int, error) {
+	for r.out.Len() == 0 && r.err == nil {
+		r.err = r.next()
+	}
+
+	if r.out.Len() > 0 {
+		return r.out.Read(p)
+	}
+	return 0, r.err
+}
+
+// Result returns the statistics collected so far. It is complete once Read
+// has returned io.EOF.
+func (r *Reader) Result() *Result {
+	return r.s.result
+}
+
+// next consumes the signature or a single chunk from the source
+func (r *Reader) next() error {
+	if !r.started {
+		signature := make([]byte, 8)
+		if _, err := io.ReadFull(r.src, signature); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return fmt.Errorf("%w: data too short", ErrNotPNG)
+			}
+			return fmt.Errorf("failed to read data: %w", err)
+		}
+		if !bytes.Equal(signature, pngSignature) {
+			return fmt.Errorf("%w: invalid signature", ErrNotPNG)
+		}
+
+		r.started = true
+		r.offset = 8
+		return r.s.write(pngSignature)
+	}
+
+	if r.s.ended {
+		// Discard everything after IEND
+		n, err := io.Copy(io.Discard, r.src)
+		if err != nil {
+			return fmt.Errorf("failed to read data: %w", err)
+		}
+		if n > 0 {
+			if err := r.s.trailingData(r.offset, int(n)); err != nil {
+				return err
+			}
+		}
+		return io.EOF
+	}
+
+	// Read chunk header
+	var header [8]byte
+	if _, err := io.ReadFull(r.src, header[:]); err != nil {
+		switch {
+		case errors.Is(err, io.EOF):
+			if err := r.s.finish(); err != nil {
+				return err
+			}
+			return io.EOF
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return &ChunkError{Offset: r.offset, Err: ErrTruncated}
+		default:
+			return fmt.Errorf("failed to read data: %w", err)
+		}
+	}
+
+	if binary.BigEndian.Uint32(header[:]) > maxChunkLength {
+		return &ChunkError{Type: string(header[4:8]), Offset: r.offset, Err: ErrChunkTooLarge}
+	}
+
+	// Read chunk data and CRC into the reused buffer, growing it only as
+	// the data arrives rather than trusting the declared length
+	size := chunkSize(header[:])
+	r.chunk.Reset()
+	r.chunk.Write(header[:])
+	if _, err := io.CopyN(&r.chunk, r.src, int64(size-8)); err != nil {
+		if errors.Is(err, io.EOF) {
+			return &ChunkError{Type: string(header[4:8]), Offset: r.offset, Err: ErrTruncated}
+		}
+		return fmt.Errorf("failed to read data: %w", err)
+	}
+
+	if err := r.s.processChunk(r.chunk.Bytes(), r.offset); err != nil {
+		return err
+	}
+
+	r.offset += size
+	return nil
+}
+
+// Writer strips metadata from PNG data written to it and forwards the kept
+// chunks to the underlying writer. Only one chunk of the input is held in
+// memory at a time, except for the chunks held by options that rewrite the
+// header or the image data.
+type Writer struct {
+	dst     io.Writer
+	s       *stripper
+	pending []byte // Buffered bytes of the incomplete chunk
+	offset  int    // Offset of the first pending byte
+	started bool   // PNG signature has been consumed
+	closed  bool
+	err     error // Sticky error
+}
+
+// NewWriter returns a writer that forwards the PNG data written to it to w
+// with unnecessary metadata removed. Close must be called to detect
+// truncated input.
+func NewWriter(w io.Writer) *Writer {
+	return NewWriterWithOptions(w, DefaultOptions())
+}
+
+// NewWriterWithOptions returns a writer that forwards the PNG data written
+// to it to w with the chunks rejected by opts removed
+func NewWriterWithOptions(w io.Writer, opts Options) *Writer {
+	return &Writer{
+		dst: w,
+		s:   newStripper(w, opts),
+	}
+}
+
+// Write implements io.Writer
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, errWriterClosed
+	}
+
+	w.pending = append(w.pending, p...)
+	consumed, err := w.drain()
+
+	// Keep only the incomplete remainder
+	w.offset += consumed
+	w.pending = w.pending[:copy(w.pending, w.pending[consumed:])]
+
+	if err != nil {
+		w.err = err
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close checks that no incomplete chunk is left. It does not close the
+// underlying writer.
+func (w *Writer) Close() error {
+	if w.err != nil || w.closed {
+		return w.err
+	}
+	w.closed = true
+
+	switch {
+	case !w.started:
+		w.err = fmt.Errorf("%w: data too short", ErrNotPNG)
+	case len(w.pending) >= 8:
+		w.err = &ChunkError{Type: string(w.pending[4:8]), Offset: w.offset, Err: ErrTruncated}
+	case len(w.pending) > 0:
+		w.err = &ChunkError{Offset: w.offset, Err: ErrTruncated}
+	default:
+		w.err = w.s.finish()
+	}
+	return w.err
+}
+
+// Result returns the statistics collected so far. It is complete once Close
+// has returned successfully.
+func (w *Writer) Result() *Result {
+	return w.s.result
+}
+
+// drain processes every complete unit in the pending buffer and returns the
+// number of bytes consumed
+func (w *Writer) drain() (int, error) {
+	consumed := 0
+
+	if !w.started {
+		if len(w.pending) < 8 {
+			return 0, nil
+		}
+		if !bytes.Equal(w.pending[:8], pngSignature) {
+			return 0, fmt.Errorf("%w: invalid signature", ErrNotPNG)
+		}
+		if _, err := w.dst.Write(pngSignature); err != nil {
+			return 0, fmt.Errorf("failed to write data: %w", err)
+		}
+
+		w.started = true
+		consumed = 8
+	}
+
+	for !w.s.ended && len(w.pending)-consumed >= 8 {
+		offset := w.offset + consumed
+		rest := w.pending[consumed:]
+
+		if binary.BigEndian.Uint32(rest) > maxChunkLength {
+			return consumed, &ChunkError{Type: string(rest[4:8]), Offset: offset, Err: ErrChunkTooLarge}
+		}
+
+		size := chunkSize(rest)
+		if len(rest) < size {
+			break
+		}
+
+		chunk := rest[:size]
+		if err := w.s.processChunk(chunk, offset); err != nil {
+			return consumed, err
+		}
+
+		consumed += size
+	}
+
+	if w.s.ended && consumed < len(w.pending) {
+		// Discard everything after IEND
+		if err := w.s.trailingData(w.offset+consumed, len(w.pending)-consumed); err != nil {
+			return consumed, err
+		}
+		consumed = len(w.pending)
+	}
+
+	return consumed, nil
+}
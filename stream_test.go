@@ -0,0 +1,151 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReaderMatchesStrip(t *testing.T) {
+	inputs := map[string][]byte{"metadata": buildMetadataPNG(t), "animated": buildAPNG(t, 3)}
+	for _, name := range []string{"with_text_chunks.png", "with_mixed_chunks.png", "animated_with_text.png"} {
+		if data, err := os.ReadFile(filepath.Join("testdata", name)); err == nil {
+			inputs[name] = data
+		}
+	}
+
+	for name, data := range inputs {
+		t.Run(name, func(t *testing.T) {
+			expected, expectedResult, err := Strip(data)
+			if err != nil {
+				t.Fatalf("Failed to process PNG: %v", err)
+			}
+
+			// Deliver the input one byte at a time to exercise chunk reassembly
+			r := NewReader(iotest.OneByteReader(bytes.NewReader(data)))
+			cleaned, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("Failed to read stripped PNG: %v", err)
+			}
+
+			if !bytes.Equal(cleaned, expected) {
+				t.Error("Reader output differs from Strip output")
+			}
+			if r.Result().Total != expectedResult.Total || r.Result().Frames != expectedResult.Frames {
+				t.Errorf("Reader result %+v differs from Strip result %+v", *r.Result(), *expectedResult)
+			}
+		})
+	}
+}
+
+func TestWriterMatchesStrip(t *testing.T) {
+	data := buildMetadataPNG(t)
+	expected, expectedResult, err := Strip(data)
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+
+	for _, size := range []int{1, 7, 13, 4096} {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		for i := 0; i < len(data); i += size {
+			end := min(i+size, len(data))
+			if _, err := w.Write(data[i:end]); err != nil {
+				t.Fatalf("Write failed with %d byte pieces: %v", size, err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close failed with %d byte pieces: %v", size, err)
+		}
+
+		if !bytes.Equal(buf.Bytes(), expected) {
+			t.Errorf("Writer output differs from Strip output with %d byte pieces", size)
+		}
+		if w.Result().Total != expectedResult.Total {
+			t.Errorf("Writer removed %d bytes, Strip removed %d", w.Result().Total, expectedResult.Total)
+		}
+	}
+}
+
+func TestStreamErrors(t *testing.T) {
+	data := buildMetadataPNG(t)
+	corrupt := bytes.Clone(data)
+	corrupt[len(corrupt)-20] ^= 0xFF
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{"Empty data", nil, ErrNotPNG},
+		{"Invalid signature", make([]byte, 16), ErrNotPNG},
+		{"Truncated header", data[:len(data)-8], ErrTruncated},
+		{"Truncated chunk", data[:len(data)-14], ErrTruncated},
+		{"Bad CRC", corrupt, ErrBadCRC},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := io.ReadAll(NewReader(bytes.NewReader(tt.data)))
+			if !errors.Is(err, tt.expected) {
+				t.Errorf("Reader: expected %v, got %v", tt.expected, err)
+			}
+
+			w := NewWriter(io.Discard)
+			_, err = w.Write(tt.data)
+			if err == nil {
+				err = w.Close()
+			}
+			if !errors.Is(err, tt.expected) {
+				t.Errorf("Writer: expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestReaderDoesNotReadAhead(t *testing.T) {
+	data := buildMetadataPNG(t)
+	src := &countingReader{r: bytes.NewReader(data)}
+	r := NewReader(src)
+
+	// The first read only needs the signature
+	if _, err := r.Read(make([]byte, 8)); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if src.n != 8 {
+		t.Errorf("Expected 8 bytes consumed after first read, got %d", src.n)
+	}
+}
+
+func TestReaderBoundsChunkBuffer(t *testing.T) {
+	// A chunk declaring the largest length followed by a few bytes
+	data := append(buildPNG(), 0x7F, 0xFF, 0xFF, 0xFF, 't', 'E', 'X', 't', 1, 2, 3, 4)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := io.ReadAll(NewReader(bytes.NewReader(data)))
+	runtime.ReadMemStats(&after)
+
+	if !errors.Is(err, ErrTruncated) {
+		t.Errorf("Expected ErrTruncated, got %v", err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Errorf("Allocated %d bytes for a %d-byte stream", allocated, len(data))
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
@@ -15,100 +15,424 @@ type Result struct {
 		TimeChunk   int // tIME
 		Background  int // bKGD
 		ExifData    int // eXIf
+		ColorChunks int // gAMA, cHRM, sRGB, iCCP, sBIT, cICP
 		OtherChunks int // All other removed chunks
 	}
-	Total int // Total bytes removed
+	Optimized struct {
+		ColorProfile        int // iCCP replaced by an equivalent sRGB chunk
+		ProfileMinimization int // iCCP stripped of non-essential tags and recompressed
+		GammaChunks         int // gAMA and cHRM replaced by an equivalent sRGB chunk
+		ImageData           int // IDAT bytes saved by re-encoding, negative if it grew
+		TextChunks          int // zTXt re-emitted as a smaller tEXt
+		IDATChunks          int // Chunk overhead saved by merging or splitting IDAT, negative if it grew
+	}
+	Total  int // Total bytes saved, removed and optimized, minus inserted chunks
+	Frames int // Number of animation frames (APNG), 0 for static images
+
+	Chunks        []ChunkInfo    // Every chunk seen, in input order
+	Inserted      []ChunkInfo    // Chunks added by Options.Insert, Offset is that of the input chunk they precede
+	RemovedByType map[string]int // Bytes removed per chunk type
+	Warnings      []Warning      // Problems repaired or tolerated
+
+	OrderViolations []Warning // Input chunks out of the order required by the PNG specification
+
+	HasTrailingData bool // Bytes followed the IEND chunk
+	TrailingData    int  // Number of bytes dropped after IEND, included in Total
+
+	Header ImageHeader // Contents of the input IHDR chunk
+
+	Orientation    int  // EXIF orientation (2-8) baked into the pixels, 0 if none
+	PixelTransform bool // Pixels were rotated or flipped by Options.ApplyOrientation
+
+	TransparentPixels int // Fully transparent pixels whose color was changed by Options.CleanTransparent
+
+	Verified     bool // Output pixels were checked to equal the input, see Options.Verify
+	KeptOriginal bool // Output was not smaller and the input was returned instead, see Options.NeverGrow
 }
 
-// Essential chunks that must be preserved
-var essentialChunks = map[string]bool{
-	// Core
-	"IHDR": true,
-	"PLTE": true,
-	"IDAT": true,
-	"IEND": true,
-	// Transparency
-	"tRNS": true,
-	// Color space
-	"gAMA": true,
-	"cHRM": true,
-	"sRGB": true,
-	"iCCP": true,
-	"sBIT": true,
-	// Physical dimensions
-	"pHYs": true,
+// ChunkInfo describes a single input chunk and what happened to it
+type ChunkInfo struct {
+	Type   string // Chunk type, e.g. "tEXt"
+	Offset int    // Offset of the chunk in the input
+	Length int    // Length of the chunk data, excluding length, type and CRC
+	Kept   bool   // Whether the chunk was written to the output
+	Reason string // Why the chunk was kept or removed, one of the Reason constants
+}
+
+// Warning describes a problem in the input that was repaired or tolerated
+type Warning struct {
+	Type    string // Chunk type, empty for problems not tied to a chunk
+	Offset  int    // Offset of the chunk in the input
+	Message string // Description of the problem and the action taken
 }
 
 // Strip removes unnecessary metadata chunks from PNG data
 func Strip(data []byte) ([]byte, *Result, error) {
+	return StripWithOptions(data, DefaultOptions())
+}
+
+// StripWithOptions removes the chunks rejected by opts from PNG data
+func StripWithOptions(data []byte, opts Options) ([]byte, *Result, error) {
+	output := bytes.NewBuffer(make([]byte, 0, len(data)))
+	s := newStripper(output, opts)
+	result, err := s.strip(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if verifies(opts) {
+		if err := s.verifyOutput(data, output.Bytes()); err != nil {
+			return data, result, err
+		}
+	}
+	if opts.NeverGrow && output.Len() >= len(data) && !s.repaired && result.TrailingData == 0 {
+		return data, originalResult(result), nil
+	}
+	return output.Bytes(), result, nil
+}
+
+// originalResult returns the result of returning the input unchanged in
+// place of the output described by result: every chunk is kept and nothing
+// is removed, optimized or inserted, but the warnings about the input remain
+func originalResult(result *Result) *Result {
+	original := &Result{
+		Frames:          result.Frames,
+		RemovedByType:   map[string]int{},
+		Warnings:        result.Warnings,
+		OrderViolations: result.OrderViolations,
+		HasTrailingData: result.HasTrailingData,
+		Header:          result.Header,
+		KeptOriginal:    true,
+	}
+	for _, info := range result.Chunks {
+		info.Kept, info.Reason = true, ReasonNeverGrow
+		original.Chunks = append(original.Chunks, info)
+	}
+	return original
+}
+
+// stripTo processes PNG data held in memory and writes the kept chunks to w
+func stripTo(w io.Writer, data []byte, opts Options) (*Result, error) {
+	return newStripper(w, opts).strip(data)
+}
+
+// strip processes PNG data held in memory
+func (s *stripper) strip(data []byte) (*Result, error) {
 	if len(data) < 8 {
-		return nil, nil, fmt.Errorf("data too short to be a PNG")
+		return nil, fmt.Errorf("%w: data too short", ErrNotPNG)
 	}
 
 	// Verify PNG signature
-	pngSignature := []byte{137, 80, 78, 71, 13, 10, 26, 10}
 	if !bytes.Equal(data[:8], pngSignature) {
-		return nil, nil, fmt.Errorf("invalid PNG signature")
+		return nil, fmt.Errorf("%w: invalid signature", ErrNotPNG)
 	}
 
-	result := &Result{}
-	output := bytes.NewBuffer(nil)
-
 	// Write PNG signature
-	output.Write(pngSignature)
+	if err := s.write(pngSignature); err != nil {
+		return nil, err
+	}
 
-	// Process chunks
+	// Process chunks up to IEND
 	offset := 8
-	for offset < len(data) {
+	for offset < len(data) && !s.ended {
 		if offset+8 > len(data) {
-			return nil, nil, fmt.Errorf("incomplete chunk at offset %d", offset)
+			return nil, &ChunkError{Offset: offset, Err: ErrTruncated}
 		}
 
-		// Read chunk length
-		length := binary.BigEndian.Uint32(data[offset : offset+4])
+		if binary.BigEndian.Uint32(data[offset:]) > maxChunkLength {
+			return nil, &ChunkError{Type: string(data[offset+4 : offset+8]), Offset: offset, Err: ErrChunkTooLarge}
+		}
 
-		if offset+12+int(length) > len(data) {
-			return nil, nil, fmt.Errorf("chunk extends beyond data at offset %d", offset)
+		// Calculate full chunk size (length + type + data + CRC)
+		fullChunkSize := chunkSize(data[offset:])
+
+		if offset+fullChunkSize > len(data) {
+			return nil, &ChunkError{Type: string(data[offset+4 : offset+8]), Offset: offset, Err: ErrTruncated}
 		}
 
-		// Read chunk type
-		chunkType := string(data[offset+4 : offset+8])
+		chunk := data[offset : offset+fullChunkSize]
+		if err := s.processChunk(chunk, offset); err != nil {
+			return nil, err
+		}
 
-		// Calculate full chunk size (length + type + data + CRC)
-		fullChunkSize := 12 + int(length)
+		offset += fullChunkSize
+	}
 
-		// Verify CRC
-		chunkData := data[offset+4 : offset+8+int(length)]
-		crc := binary.BigEndian.Uint32(data[offset+8+int(length) : offset+12+int(length)])
-		calculatedCRC := crc32.ChecksumIEEE(chunkData)
+	if err := s.finish(); err != nil {
+		return nil, err
+	}
 
-		if crc != calculatedCRC {
-			return nil, nil, fmt.Errorf("invalid CRC for chunk %s at offset %d", chunkType, offset)
+	if offset < len(data) {
+		if err := s.trailingData(offset, len(data)-offset); err != nil {
+			return nil, err
 		}
+	}
 
-		// Decide whether to keep the chunk
-		if shouldKeepChunk(chunkType) {
-			// Write the entire chunk
-			output.Write(data[offset : offset+fullChunkSize])
-		} else {
-			// Track removed chunk
-			trackRemovedChunk(result, chunkType, fullChunkSize)
+	return s.result, nil
+}
+
+// PNG file signature
+var pngSignature = []byte{137, 80, 78, 71, 13, 10, 26, 10}
+
+// chunkSize returns the full size (length + type + data + CRC) of the chunk
+// whose header starts at header[0]
+func chunkSize(header []byte) int {
+	return 12 + int(binary.BigEndian.Uint32(header[0:4]))
+}
+
+// verifyCRC checks the CRC of a complete chunk found at offset
+func verifyCRC(chunk []byte, offset int) error {
+	end := len(chunk) - 4
+	crc := binary.BigEndian.Uint32(chunk[end:])
+	if calculated := crc32.ChecksumIEEE(chunk[4:end]); crc != calculated {
+		return &ChunkError{
+			Type:     string(chunk[4:8]),
+			Offset:   offset,
+			Expected: calculated,
+			Actual:   crc,
+			Err:      ErrBadCRC,
 		}
+	}
+	return nil
+}
 
-		offset += fullChunkSize
+// stripper applies a strip policy to a sequence of validated chunks and
+// writes the kept ones to w
+type stripper struct {
+	w      io.Writer
+	policy *policy
+	result *Result
+	seq    uint32 // Next APNG sequence number
+	ended  bool   // IEND has been processed
+
+	hasSRGB bool // An sRGB chunk has been written
+
+	// Output chunks from IHDR up to the first PLTE or IDAT are held while
+	// color chunks are reconciled, or up to IEND when the image is rewritten
+	holding bool
+	held    []heldChunk
+
+	orientation int // EXIF orientation to bake into the pixels
+
+	order    orderState // Input chunk order checked so far
+	repaired bool       // A chunk was repaired, moved or dropped by Lenient or RepairOrder
+
+	// IDAT data waiting to be written by Options.MergeIDAT or SplitIDAT
+	idat        []byte
+	idatRun     bool // The last chunk was an IDAT chunk
+	idatWritten int  // IDAT chunks written for the current run
+}
+
+func newStripper(w io.Writer, opts Options) *stripper {
+	return &stripper{
+		w:      w,
+		policy: newPolicy(opts),
+		result: &Result{RemovedByType: map[string]int{}},
 	}
+}
 
-	return output.Bytes(), result, nil
+// processChunk validates a single chunk and decides its fate. The chunk
+// slice is not retained after the call returns.
+func (s *stripper) processChunk(chunk []byte, offset int) error {
+	chunkType := string(chunk[4:8])
+
+	if err := verifyCRC(chunk, offset); err != nil {
+		if !s.policy.opts.Lenient {
+			return err
+		}
+
+		repaired, err := s.repairChunk(chunk, offset, err)
+		if err != nil || repaired == nil {
+			return err
+		}
+		chunk = repaired
+	}
+
+	s.observe(chunkType, chunk[8:len(chunk)-4])
+	if chunkType != "IDAT" {
+		if err := s.endIDAT(); err != nil {
+			return err
+		}
+	}
+
+	s.checkOrder(chunkType, offset)
+
+	// Header chunks are held until the first PLTE or IDAT, and all chunks
+	// until IEND when the image data is rewritten or reordered
+	endOfHeld := chunkType == "IEND" ||
+		!holdsChunks(s.policy.opts) && (chunkType == "PLTE" || chunkType == "IDAT")
+	if s.holding && endOfHeld {
+		if err := s.flush(offset); err != nil {
+			return err
+		}
+	}
+
+	keep, reason := s.policy.shouldKeepChunk(chunkType)
+	if reason == ReasonText {
+		keep, reason = s.policy.shouldKeepText(chunk[8 : len(chunk)-4])
+	}
+	s.result.Chunks = append(s.result.Chunks, ChunkInfo{
+		Type:   chunkType,
+		Offset: offset,
+		Length: len(chunk) - 12,
+		Kept:   keep,
+		Reason: reason,
+	})
+
+	// Decide whether to keep the chunk
+	switch {
+	case isSequencedChunk(chunkType):
+		return s.writeSequenced(chunk, offset)
+	case chunkType == "IDAT" && rewritesIDAT(s.policy.opts):
+		return s.bufferIDAT(chunk)
+	case keep:
+		return s.writeKept(chunk)
+	default:
+		return s.processRemoved(chunk, reason)
+	}
+}
+
+// observe records what the stripper needs to know from a chunk before it
+// is processed
+func (s *stripper) observe(chunkType string, data []byte) {
+	switch chunkType {
+	case "IHDR":
+		s.result.Header = parseImageHeader(data)
+		s.holding = holdsHeader(s.policy.opts)
+	case "eXIf":
+		if s.policy.opts.ApplyOrientation {
+			s.orientation = exifOrientation(data)
+		}
+	case "IEND":
+		s.ended = true
+	}
+}
+
+// writeSequenced renumbers an fcTL or fdAT chunk and writes it. Both share
+// a single sequence that must stay contiguous.
+func (s *stripper) writeSequenced(chunk []byte, offset int) error {
+	chunkType := string(chunk[4:8])
+	renumbered, err := renumberChunk(chunk, s.seq)
+	if err != nil {
+		return &ChunkError{Type: chunkType, Offset: offset, Err: err}
+	}
+	s.seq++
+
+	if chunkType == "fcTL" {
+		s.result.Frames++
+	}
+	return s.write(renumbered)
+}
+
+// writeKept writes a chunk kept by the policy, rewriting or dropping it as
+// required by the options that apply to its type
+func (s *stripper) writeKept(chunk []byte) error {
+	chunkType := string(chunk[4:8])
+	opts := s.policy.opts
+
+	switch {
+	case s.policy.replacedByInsertion(chunkType, chunk[8:len(chunk)-4]):
+		s.dropChunk(chunk, ReasonReplaced)
+		return nil
+	case chunkType == "iCCP" && (opts.ReplaceSRGBProfile || opts.MinimizeProfile):
+		return s.processICCP(chunk)
+	case chunkType == "zTXt" && opts.UncompressText:
+		return s.processZTXt(chunk)
+	case chunkType == "sRGB":
+		if s.hasSRGB {
+			s.dropChunk(chunk, ReasonDuplicate)
+			return nil
+		}
+		s.hasSRGB = true
+		return s.write(chunk)
+	default:
+		// Write the entire chunk
+		return s.write(chunk)
+	}
+}
+
+// processRemoved tracks a chunk removed by the policy, except for the parts
+// of XMP and EXIF metadata selected by XMPProperties and ExifTags
+func (s *stripper) processRemoved(chunk []byte, reason string) error {
+	chunkType := string(chunk[4:8])
+	opts := s.policy.opts
+
+	switch {
+	case reason == ReasonText && chunkType == "iTXt" && len(opts.XMPProperties) > 0 &&
+		textKeyword(chunk[8:len(chunk)-4]) == xmpKeyword:
+		return s.processXMP(chunk)
+	case reason == ReasonExif && len(opts.ExifTags) > 0:
+		return s.processExif(chunk)
+	default:
+		// Track removed chunk
+		trackRemovedChunk(s.result, chunkType, len(chunk))
+		return nil
+	}
+}
+
+// currentChunk returns the log entry of the chunk being processed
+func (s *stripper) currentChunk() *ChunkInfo {
+	return &s.result.Chunks[len(s.result.Chunks)-1]
+}
+
+// dropChunk removes a chunk the policy would have kept, recording reason in
+// the entry of the chunk being processed
+func (s *stripper) dropChunk(chunk []byte, reason string) {
+	info := s.currentChunk()
+	info.Kept = false
+	info.Reason = reason
+	trackRemovedChunk(s.result, info.Type, len(chunk))
+}
+
+// finish writes any chunks still held back when the input ends without
+// reaching PLTE, IDAT or IEND
+func (s *stripper) finish() error {
+	if err := s.endIDAT(); err != nil {
+		return err
+	}
+	if s.holding {
+		return s.flush(-1)
+	}
+	return nil
 }
 
-// shouldKeepChunk determines if a chunk should be preserved
-func shouldKeepChunk(chunkType string) bool {
-	return essentialChunks[chunkType]
+// trailingData records n bytes found at offset after the IEND chunk
+func (s *stripper) trailingData(offset, n int) error {
+	if s.policy.opts.RejectTrailingData {
+		return fmt.Errorf("%w: %d bytes at offset %d", ErrTrailingData, n, offset)
+	}
+
+	s.result.HasTrailingData = true
+	s.result.TrailingData += n
+	s.result.Total += n
+	return nil
+}
+
+// buildChunk assembles a chunk with its length and CRC
+func buildChunk(chunkType string, data []byte) []byte {
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk[0:4], uint32(len(data)))
+	copy(chunk[4:8], chunkType)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+func (s *stripper) write(chunk []byte) error {
+	if s.holding {
+		s.hold(chunk)
+		return nil
+	}
+
+	if _, err := s.w.Write(chunk); err != nil {
+		return fmt.Errorf("failed to write data: %w", err)
+	}
+	return nil
 }
 
 // trackRemovedChunk updates the result statistics
 func trackRemovedChunk(result *Result, chunkType string, size int) {
 	result.Total += size
+	result.RemovedByType[chunkType] += size
 
 	switch chunkType {
 	case "tEXt", "zTXt", "iTXt":
@@ -120,23 +444,39 @@ func trackRemovedChunk(result *Result, chunkType string, size int) {
 	case "eXIf":
 		result.Removed.ExifData += size
 	default:
-		result.Removed.OtherChunks += size
+		if colorChunks[chunkType] {
+			result.Removed.ColorChunks += size
+		} else {
+			result.Removed.OtherChunks += size
+		}
 	}
 }
 
 // PngMetaWebStripReader processes PNG data from a reader
 func PngMetaWebStripReader(r io.Reader) ([]byte, *Result, error) {
+	return PngMetaWebStripReaderWithOptions(r, DefaultOptions())
+}
+
+// PngMetaWebStripReaderWithOptions processes PNG data from a reader using opts.
+// The whole input is read into memory and processed by StripWithOptions, so
+// NeverGrow and Verify apply; use NewReaderWithOptions to stream instead.
+func PngMetaWebStripReaderWithOptions(r io.Reader, opts Options) ([]byte, *Result, error) {
 	data, err := io.ReadAll(r)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to read data: %w", err)
 	}
 
-	return Strip(data)
+	return StripWithOptions(data, opts)
 }
 
 // PngMetaWebStripWriter processes PNG data and writes to a writer
 func PngMetaWebStripWriter(data []byte, w io.Writer) (*Result, error) {
-	cleaned, result, err := Strip(data)
+	return PngMetaWebStripWriterWithOptions(data, w, DefaultOptions())
+}
+
+// PngMetaWebStripWriterWithOptions processes PNG data using opts and writes to a writer
+func PngMetaWebStripWriterWithOptions(data []byte, w io.Writer, opts Options) (*Result, error) {
+	cleaned, result, err := StripWithOptions(data, opts)
 	if err != nil {
 		return nil, err
 	}
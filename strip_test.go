@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"crypto/md5"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"image"
 	"image/png"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -57,6 +60,9 @@ func TestPngMetaWebStrip(t *testing.T) {
 		{"Preserve significant bits", "with_significant_bits.png", false, ""},
 		{"Remove all removable", "with_all_removable.png", true, "multiple"},
 		{"Mixed chunks", "with_mixed_chunks.png", true, "mixed"},
+		{"Preserve animation", "animated.png", false, ""},
+		{"Remove text from animation", "animated_with_text.png", true, "text"},
+		{"Remove trailing data", "with_trailing_data.png", true, ""},
 	}
 
 	for _, tt := range testFiles {
@@ -210,6 +216,124 @@ func TestPngMetaWebStripWriter(t *testing.T) {
 	}
 }
 
+func TestChunkLog(t *testing.T) {
+	data := buildMetadataPNG(t)
+
+	cleaned, result, err := StripWithOptions(data, Options{KeepColor: true, Drop: []string{"tIME"}})
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+
+	expected := []struct {
+		chunkType string
+		kept      bool
+		reason    string
+	}{
+		{"IHDR", true, ReasonRequired},
+		{"gAMA", true, ReasonColor},
+		{"pHYs", false, ReasonPhysical},
+		{"bKGD", false, ReasonOther},
+		{"tIME", false, ReasonDropList},
+		{"eXIf", false, ReasonExif},
+		{"tEXt", false, ReasonText},
+		{"IDAT", true, ReasonRequired},
+		{"IEND", true, ReasonRequired},
+	}
+
+	if len(result.Chunks) != len(expected) {
+		t.Fatalf("Expected %d chunk entries, got %d", len(expected), len(result.Chunks))
+	}
+
+	offset := 8
+	removed := 0
+	for i, info := range result.Chunks {
+		want := expected[i]
+		if info.Type != want.chunkType || info.Kept != want.kept || info.Reason != want.reason {
+			t.Errorf("Entry %d: expected %s kept=%v reason=%q, got %+v", i, want.chunkType, want.kept, want.reason, info)
+		}
+		if info.Offset != offset {
+			t.Errorf("Entry %d: expected offset %d, got %d", i, offset, info.Offset)
+		}
+		if !info.Kept {
+			removed += info.Length + 12
+			if result.RemovedByType[info.Type] != info.Length+12 {
+				t.Errorf("RemovedByType[%s] = %d, expected %d", info.Type, result.RemovedByType[info.Type], info.Length+12)
+			}
+		}
+		offset += info.Length + 12
+	}
+
+	if removed != result.Total || removed != len(data)-len(cleaned) {
+		t.Errorf("Removed entries add up to %d, Total is %d", removed, result.Total)
+	}
+	if result.Removed.OtherChunks != result.RemovedByType["pHYs"] {
+		t.Error("RemovedByType does not break down OtherChunks")
+	}
+}
+
+func TestTrailingData(t *testing.T) {
+	original := buildMetadataPNG(t)
+	expected, _, err := Strip(original)
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+
+	// A ZIP local file header appended after IEND, as in polyglot files
+	trailer := append([]byte("PK\x03\x04"), bytes.Repeat([]byte{0xAB}, 60)...)
+	data := append(bytes.Clone(original), trailer...)
+
+	strip := func(data []byte, opts Options) ([]byte, *Result, error) {
+		return StripWithOptions(data, opts)
+	}
+	reader := func(data []byte, opts Options) ([]byte, *Result, error) {
+		r := NewReaderWithOptions(bytes.NewReader(data), opts)
+		cleaned, err := io.ReadAll(r)
+		return cleaned, r.Result(), err
+	}
+	writer := func(data []byte, opts Options) ([]byte, *Result, error) {
+		var buf bytes.Buffer
+		w := NewWriterWithOptions(&buf, opts)
+		// Split inside the trailer to check it is never parsed as a chunk
+		if _, err := w.Write(data[:len(data)-30]); err != nil {
+			return nil, nil, err
+		}
+		if _, err := w.Write(data[len(data)-30:]); err != nil {
+			return nil, nil, err
+		}
+		return buf.Bytes(), w.Result(), w.Close()
+	}
+
+	for name, process := range map[string]func([]byte, Options) ([]byte, *Result, error){
+		"Strip": strip, "Reader": reader, "Writer": writer,
+	} {
+		t.Run(name, func(t *testing.T) {
+			cleaned, result, err := process(data, DefaultOptions())
+			if err != nil {
+				t.Fatalf("Failed to process PNG with trailing data: %v", err)
+			}
+			if !bytes.Equal(cleaned, expected) {
+				t.Error("Trailing data was not dropped")
+			}
+			if !result.HasTrailingData || result.TrailingData != len(trailer) {
+				t.Errorf("Expected %d trailing bytes reported, got %d (flag %v)",
+					len(trailer), result.TrailingData, result.HasTrailingData)
+			}
+			if result.Total != len(data)-len(cleaned) {
+				t.Errorf("Total %d does not include trailing data", result.Total)
+			}
+
+			opts := DefaultOptions()
+			opts.RejectTrailingData = true
+			if _, _, err := process(data, opts); !errors.Is(err, ErrTrailingData) {
+				t.Errorf("Expected ErrTrailingData, got %v", err)
+			}
+			if _, result, err := process(original, opts); err != nil || result.HasTrailingData {
+				t.Errorf("Clean file rejected or flagged: %v", err)
+			}
+		})
+	}
+}
+
 // Helper functions
 
 func validatePNG(data []byte) error {
@@ -217,6 +341,60 @@ func validatePNG(data []byte) error {
 	return err
 }
 
+func makeChunk(chunkType string, data []byte) []byte {
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk[0:4], uint32(len(data)))
+	copy(chunk[4:8], chunkType)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+func buildPNG(chunks ...[]byte) []byte {
+	data := []byte{137, 80, 78, 71, 13, 10, 26, 10}
+	for _, chunk := range chunks {
+		data = append(data, chunk...)
+	}
+	return data
+}
+
+// encodedChunks returns the chunks of an image encoded by image/png
+func encodedChunks(t testing.TB, img image.Image) map[string][]byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Failed to encode test image: %v", err)
+	}
+
+	return encodedChunksFromPNG(buf.Bytes())
+}
+
+// encodedChunksFromPNG returns the payload of each chunk type in a PNG
+func encodedChunksFromPNG(data []byte) map[string][]byte {
+	chunks := map[string][]byte{}
+	offset := 8
+	for offset+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		chunkType := string(data[offset+4 : offset+8])
+		// image/png may split IDAT; join the payloads
+		chunks[chunkType] = append(chunks[chunkType], data[offset+8:offset+8+length]...)
+		offset += 12 + length
+	}
+
+	return chunks
+}
+
+func chunkTypes(data []byte) []string {
+	var types []string
+	offset := 8
+	for offset+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		types = append(types, string(data[offset+4:offset+8]))
+		offset += 12 + length
+	}
+	return types
+}
+
 func hasChunk(data []byte, chunkType string) bool {
 	if len(data) < 8 {
 		return false
@@ -444,6 +622,9 @@ func TestGenerateReport(t *testing.T) {
 		if result.Removed.ExifData > 0 {
 			removedTypes = append(removedTypes, "exif")
 		}
+		if result.Removed.ColorChunks > 0 {
+			removedTypes = append(removedTypes, "color")
+		}
 		if result.Removed.OtherChunks > 0 {
 			removedTypes = append(removedTypes, "other")
 		}
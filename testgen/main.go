@@ -33,6 +33,9 @@ func main() {
 	generateWithTransparency()
 	generateIndexedColor()
 	generateWithSBIT(img)
+	generateAnimated(img, false)
+	generateAnimated(img, true)
+	generateWithTrailingData(img)
 
 	fmt.Println("Test data generation complete!")
 }
@@ -358,6 +361,101 @@ func generateWithSBIT(img image.Image) {
 	}
 }
 
+func generateAnimated(img image.Image, withText bool) {
+	var buf bytes.Buffer
+
+	// Write PNG signature
+	buf.Write([]byte{137, 80, 78, 71, 13, 10, 26, 10})
+
+	// Encode the default image and two further frames
+	frames := []image.Image{img, createFrameImage(255, 0), createFrameImage(0, 255)}
+	encoded := make([][]byte, len(frames))
+	for i, frame := range frames {
+		var imgBuf bytes.Buffer
+		if err := png.Encode(&imgBuf, frame); err != nil {
+			log.Fatalf("Failed to encode PNG: %v", err)
+		}
+		encoded[i] = imgBuf.Bytes()
+	}
+
+	// Copy IHDR
+	copyChunk(&buf, encoded[0], "IHDR")
+
+	// Add acTL chunk (3 frames, infinite loop)
+	actlData := make([]byte, 8)
+	binary.BigEndian.PutUint32(actlData[0:4], uint32(len(frames)))
+	writeChunk(&buf, "acTL", actlData)
+
+	if withText {
+		writeTextChunk(&buf, "Software", "testgen")
+	}
+
+	seq := uint32(0)
+	for i := range frames {
+		// Add fcTL chunk (full frame, 500ms delay)
+		fctlData := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctlData[0:4], seq)
+		binary.BigEndian.PutUint32(fctlData[4:8], 100)  // Width
+		binary.BigEndian.PutUint32(fctlData[8:12], 100) // Height
+		binary.BigEndian.PutUint16(fctlData[20:22], 1)  // Delay numerator
+		binary.BigEndian.PutUint16(fctlData[22:24], 2)  // Delay denominator
+		writeChunk(&buf, "fcTL", fctlData)
+		seq++
+
+		if i == 0 {
+			// The default image doubles as the first frame
+			copyChunk(&buf, encoded[i], "IDAT")
+			if withText {
+				writeTextChunk(&buf, "Comment", "Between frames")
+			}
+			continue
+		}
+
+		// Add fdAT chunk carrying the frame's compressed data
+		fdatData := make([]byte, 4)
+		binary.BigEndian.PutUint32(fdatData, seq)
+		fdatData = append(fdatData, chunkData(encoded[i], "IDAT")...)
+		writeChunk(&buf, "fdAT", fdatData)
+		seq++
+	}
+
+	copyChunk(&buf, encoded[0], "IEND")
+
+	filename := "testdata/animated.png"
+	if withText {
+		filename = "testdata/animated_with_text.png"
+	}
+	if err := os.WriteFile(filename, buf.Bytes(), 0600); err != nil {
+		log.Fatalf("Failed to write file: %v", err)
+	}
+}
+
+func generateWithTrailingData(img image.Image) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		log.Fatalf("Failed to encode PNG: %v", err)
+	}
+
+	// Append a ZIP local file header after IEND, as found in polyglot files
+	buf.Write([]byte{'P', 'K', 0x03, 0x04})
+	buf.Write(bytes.Repeat([]byte{0}, 26))
+	buf.WriteString("payload.txt")
+
+	if err := os.WriteFile("testdata/with_trailing_data.png", buf.Bytes(), 0600); err != nil {
+		log.Fatalf("Failed to write file: %v", err)
+	}
+}
+
+func createFrameImage(red, green uint8) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 100; x++ {
+			img.Set(x, y, color.RGBA{red, green, uint8((255 * x) / 100), 255})
+		}
+	}
+	return img
+}
+
 // Helper functions
 
 func writeChunk(buf *bytes.Buffer, chunkType string, data []byte) {
@@ -410,3 +508,17 @@ func copyChunk(dest *bytes.Buffer, src []byte, chunkType string) {
 		log.Fatalf("Required chunk %s not found", chunkType)
 	}
 }
+
+func chunkData(src []byte, chunkType string) []byte {
+	offset := 8 // Skip PNG signature
+	for offset+8 <= len(src) {
+		length := int(binary.BigEndian.Uint32(src[offset : offset+4]))
+		if string(src[offset+4:offset+8]) == chunkType {
+			return src[offset+8 : offset+8+length]
+		}
+		offset += 12 + length
+	}
+
+	log.Fatalf("Required chunk %s not found", chunkType)
+	return nil
+}
@@ -0,0 +1,64 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+)
+
+// textKeyword returns the keyword that starts the data of a tEXt, zTXt or
+// iTXt chunk
+func textKeyword(data []byte) string {
+	if i := bytes.IndexByte(data, 0); i > 0 {
+		return string(data[:i])
+	}
+	return ""
+}
+
+// shouldKeepText refines the Options.KeepText decision for a text chunk
+// using the keyword lists
+func (p *policy) shouldKeepText(data []byte) (bool, string) {
+	keyword := textKeyword(data)
+	switch {
+	case p.dropText[keyword]:
+		return false, ReasonTextKeyword
+	case p.keepText[keyword]:
+		return true, ReasonTextKeyword
+	default:
+		return p.opts.KeepText, ReasonText
+	}
+}
+
+// processZTXt writes a kept zTXt chunk as tEXt when the text takes less
+// space uncompressed, and the chunk itself otherwise
+func (s *stripper) processZTXt(chunk []byte) error {
+	data := chunk[8 : len(chunk)-4]
+	sep := bytes.IndexByte(data, 0)
+	if sep < 1 || sep+2 > len(data) || data[sep+1] != 0 {
+		return s.write(chunk)
+	}
+
+	// tEXt is smaller only if the text is no longer than the compressed
+	// stream, so decompression can stop one byte past that
+	compressed := data[sep+2:]
+	zr, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return s.write(chunk)
+	}
+	defer zr.Close()
+
+	text, err := io.ReadAll(io.LimitReader(zr, int64(len(compressed))+1))
+	if err != nil || len(text) > len(compressed) {
+		return s.write(chunk)
+	}
+
+	plain := make([]byte, 0, sep+1+len(text))
+	plain = append(plain, data[:sep+1]...)
+	plain = append(plain, text...)
+	replacement := buildChunk("tEXt", plain)
+
+	saved := len(chunk) - len(replacement)
+	s.result.Optimized.TextChunks += saved
+	s.result.Total += saved
+	return s.write(replacement)
+}
@@ -0,0 +1,122 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"compress/zlib"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func ztxtData(t *testing.T, keyword, text string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	buf.WriteString(keyword)
+	buf.Write([]byte{0, 0})
+	zw, _ := zlib.NewWriterLevel(&buf, zlib.BestCompression)
+	if _, err := zw.Write([]byte(text)); err != nil {
+		t.Fatalf("Failed to compress text: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to compress text: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestTextKeywords(t *testing.T) {
+	data := buildICCPNG(t,
+		makeChunk("tEXt", []byte("Copyright\x00(c) Example")),
+		makeChunk("tEXt", []byte("Comment\x00Made with love")),
+		makeChunk("zTXt", ztxtData(t, "License", "CC BY 4.0")),
+		makeChunk("iTXt", []byte("Source\x00\x00\x00en\x00\x00https://example.com")),
+		makeChunk("tEXt", []byte("Software\x00Editor 1.0")),
+		makeChunk("tEXt", []byte("Creation Time\x002024-01-01")),
+	)
+
+	tests := []struct {
+		name     string
+		opts     Options
+		expected []string
+	}{
+		{
+			name:     "Allowlist",
+			opts:     Options{KeepTextKeywords: []string{"Copyright", "License", "Source"}},
+			expected: []string{"Copyright", "License", "Source"},
+		},
+		{
+			name:     "Denylist",
+			opts:     Options{KeepText: true, DropTextKeywords: []string{"Comment", "Software", "Creation Time"}},
+			expected: []string{"Copyright", "License", "Source"},
+		},
+		{
+			name: "Denylist wins",
+			opts: Options{
+				KeepTextKeywords: []string{"Copyright", "Comment"},
+				DropTextKeywords: []string{"Comment"},
+			},
+			expected: []string{"Copyright"},
+		},
+		{
+			name:     "Drop list wins",
+			opts:     Options{KeepTextKeywords: []string{"Copyright"}, Drop: []string{"tEXt"}},
+			expected: nil,
+		},
+		{
+			name:     "Keywords are case-sensitive",
+			opts:     Options{KeepTextKeywords: []string{"copyright"}},
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cleaned, result, err := StripWithOptions(data, tt.opts)
+			if err != nil {
+				t.Fatalf("Failed to process PNG: %v", err)
+			}
+
+			var keywords []string
+			for _, info := range result.Chunks {
+				if textChunks[info.Type] && info.Kept {
+					keywords = append(keywords, textKeyword(data[info.Offset+8:]))
+				}
+			}
+			if !slices.Equal(keywords, tt.expected) {
+				t.Errorf("Expected keywords %v to be kept, got %v", tt.expected, keywords)
+			}
+			if result.Total != len(data)-len(cleaned) {
+				t.Errorf("Total is %d, expected %d", result.Total, len(data)-len(cleaned))
+			}
+		})
+	}
+}
+
+func TestUncompressText(t *testing.T) {
+	short := makeChunk("zTXt", ztxtData(t, "Copyright", "(c) Example"))
+	long := makeChunk("zTXt", ztxtData(t, "Comment", strings.Repeat("compressible ", 100)))
+	data := buildICCPNG(t, short, long)
+
+	opts := Options{KeepText: true, UncompressText: true}
+	cleaned, result, err := StripWithOptions(data, opts)
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+
+	plain := makeChunk("tEXt", []byte("Copyright\x00(c) Example"))
+	if !bytes.Contains(cleaned, plain) {
+		t.Error("Expected the short zTXt to become tEXt")
+	}
+	if !bytes.Contains(cleaned, long) {
+		t.Error("Expected the long zTXt to stay compressed")
+	}
+	if result.Optimized.TextChunks != len(short)-len(plain) || result.Total != len(data)-len(cleaned) {
+		t.Errorf("Unexpected savings: text=%d total=%d", result.Optimized.TextChunks, result.Total)
+	}
+
+	// Corrupt streams are kept as they are
+	corrupt := buildICCPNG(t, makeChunk("zTXt", []byte("Comment\x00\x00garbage")))
+	if cleaned, _, _ := StripWithOptions(corrupt, opts); !bytes.Equal(cleaned, corrupt) {
+		t.Error("Expected a corrupt zTXt to be kept verbatim")
+	}
+}
@@ -0,0 +1,47 @@
+package pngmetawebstrip
+
+import "bytes"
+
+// TransparentFill selects how Options.CleanTransparent replaces the color
+// values of fully transparent pixels
+type TransparentFill int
+
+const (
+	TransparentKeep     TransparentFill = iota // Leave the color values unchanged
+	TransparentZero                            // Set the color values to zero (black)
+	TransparentNeighbor                        // Copy the color of the pixel to the left, or above in the first column
+)
+
+// cleanTransparent replaces the color samples of fully transparent pixels of
+// images with an alpha channel and returns the number of pixels changed
+func (r *raster) cleanTransparent(fill TransparentFill) int {
+	if fill == TransparentKeep || r.colorType != 4 && r.colorType != 6 {
+		return 0
+	}
+
+	size := r.pixelSize()
+	colorSize := size - size/channels[r.colorType] // Alpha is the last sample
+	zero := make([]byte, colorSize)
+	changed := 0
+	for i := 0; i < r.width*r.height; i++ {
+		p := r.pix[i*size : (i+1)*size]
+		if !bytes.Equal(p[colorSize:], zero[:size-colorSize]) {
+			continue
+		}
+
+		src := zero
+		if fill == TransparentNeighbor {
+			switch {
+			case i%r.width > 0:
+				src = r.pix[(i-1)*size : (i-1)*size+colorSize]
+			case i >= r.width:
+				src = r.pix[(i-r.width)*size : (i-r.width)*size+colorSize]
+			}
+		}
+		if !bytes.Equal(p[:colorSize], src) {
+			copy(p, src)
+			changed++
+		}
+	}
+	return changed
+}
@@ -0,0 +1,110 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// hiddenContentImage returns an image whose right half is fully transparent
+// but still holds colors
+func hiddenContentImage() *image.NRGBA {
+	return filledNRGBA(func(x, y int) color.NRGBA {
+		c := color.NRGBA{uint8(noise(x, y, 256)), uint8(x * 4), uint8(y * 4), 255}
+		if x >= 32 {
+			c.A = 0
+		}
+		return c
+	})
+}
+
+// assertSameVisiblePixels fails the test unless two images look the same,
+// comparing alpha-premultiplied colors
+func assertSameVisiblePixels(t *testing.T, expected, actual image.Image) {
+	t.Helper()
+
+	for y := expected.Bounds().Min.Y; y < expected.Bounds().Max.Y; y++ {
+		for x := expected.Bounds().Min.X; x < expected.Bounds().Max.X; x++ {
+			er, eg, eb, ea := expected.At(x, y).RGBA()
+			ar, ag, ab, aa := actual.At(x, y).RGBA()
+			if er != ar || eg != ag || eb != ab || ea != aa {
+				t.Fatalf("Pixel (%d, %d) looks different", x, y)
+			}
+		}
+	}
+}
+
+func TestCleanTransparent(t *testing.T) {
+	img := hiddenContentImage()
+	data := reductionPNG(t, img)
+
+	for _, fill := range []TransparentFill{TransparentZero, TransparentNeighbor} {
+		opts := DefaultOptions()
+		opts.CleanTransparent = fill
+		cleaned, result, err := StripWithOptions(data, opts)
+		if err != nil {
+			t.Fatalf("Failed to process PNG: %v", err)
+		}
+		if result.TransparentPixels != 32*64 {
+			t.Errorf("Fill %d: expected %d pixels cleaned, got %d", fill, 32*64, result.TransparentPixels)
+		}
+		if len(cleaned) >= len(data) || result.Total != len(data)-len(cleaned) {
+			t.Errorf("Fill %d: expected a smaller image, got %d bytes from %d (Total %d)", fill, len(cleaned), len(data), result.Total)
+		}
+
+		decoded, err := png.Decode(bytes.NewReader(cleaned))
+		if err != nil {
+			t.Fatalf("Failed to decode cleaned PNG: %v", err)
+		}
+		assertSameVisiblePixels(t, img, decoded)
+
+		nrgba := decoded.(*image.NRGBA)
+		for y := 0; y < 64; y++ {
+			expected := color.NRGBA{}
+			if fill == TransparentNeighbor {
+				expected = img.NRGBAAt(31, y)
+				expected.A = 0
+			}
+			if c := nrgba.NRGBAAt(40, y); c != expected {
+				t.Fatalf("Fill %d: expected %v under transparent pixel, got %v", fill, expected, c)
+			}
+		}
+
+		// Cleaning again changes nothing
+		again, result, err := StripWithOptions(cleaned, opts)
+		if err != nil {
+			t.Fatalf("Failed to process PNG: %v", err)
+		}
+		if result.TransparentPixels != 0 || !bytes.Equal(again, cleaned) {
+			t.Errorf("Fill %d: clean image rewritten, %d pixels changed", fill, result.TransparentPixels)
+		}
+	}
+}
+
+func TestCleanTransparentWide(t *testing.T) {
+	img := image.NewNRGBA64(image.Rect(0, 0, 8, 8))
+	for i := 0; i < 64; i++ {
+		img.SetNRGBA64(i%8, i/8, color.NRGBA64{uint16(i * 1000), 0x1234, 0xFFFF, uint16(i%2) * 0xFFFF})
+	}
+
+	opts := DefaultOptions()
+	opts.CleanTransparent = TransparentZero
+	cleaned, result, err := StripWithOptions(reductionPNG(t, img), opts)
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+	if result.TransparentPixels != 32 {
+		t.Errorf("Expected 32 pixels cleaned, got %d", result.TransparentPixels)
+	}
+
+	decoded, err := png.Decode(bytes.NewReader(cleaned))
+	if err != nil {
+		t.Fatalf("Failed to decode cleaned PNG: %v", err)
+	}
+	assertSameVisiblePixels(t, img, decoded)
+	if c := decoded.(*image.NRGBA64).NRGBA64At(0, 0); c != (color.NRGBA64{}) {
+		t.Errorf("Expected a zero transparent pixel, got %v", c)
+	}
+}
@@ -0,0 +1,110 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// VerifyPixelsEqual decodes two PNG images and reports whether their pixels
+// are identical, returning an error wrapping ErrPixelMismatch when they are
+// not. Colors are compared at full precision after applying PLTE and tRNS,
+// so images in different color types or interlace methods compare equal
+// when they display the same; the color values of fully transparent pixels
+// are ignored. Only the static image is compared, not APNG frames. Images
+// that cannot be decoded, including those with more pixels than an
+// 8192x8192 image, return an error wrapping ErrInvalidChunk.
+func VerifyPixelsEqual(a, b []byte) error {
+	ha, pa, err := decodePixels(a, 1)
+	if err != nil {
+		return err
+	}
+	hb, pb, err := decodePixels(b, 1)
+	if err != nil {
+		return err
+	}
+	return comparePixels(ha, pa, hb, pb)
+}
+
+// comparePixels compares the decoded pixels of two images
+func comparePixels(ha ImageHeader, pa [][4]uint16, hb ImageHeader, pb [][4]uint16) error {
+	if ha.Width != hb.Width || ha.Height != hb.Height {
+		return fmt.Errorf("%w: size %dx%d differs from %dx%d", ErrPixelMismatch, hb.Width, hb.Height, ha.Width, ha.Height)
+	}
+	for i := range pa {
+		if pa[i] != pb[i] && (pa[i][3] != 0 || pb[i][3] != 0) {
+			return fmt.Errorf("%w: pixel (%d, %d) differs", ErrPixelMismatch, i%ha.Width, i/ha.Width)
+		}
+	}
+	return nil
+}
+
+// decodePixels decodes the static image of PNG data to 16-bit RGBA, rotated
+// and flipped by an EXIF orientation. CRCs are not checked.
+func decodePixels(data []byte, orientation int) (ImageHeader, [][4]uint16, error) {
+	if len(data) < 8 || !bytes.Equal(data[:8], pngSignature) {
+		return ImageHeader{}, nil, ErrNotPNG
+	}
+
+	var h ImageHeader
+	var plte, trns, idat []byte
+	for offset := 8; offset < len(data); {
+		if offset+12 > len(data) || offset+chunkSize(data[offset:]) > len(data) {
+			return h, nil, &ChunkError{Offset: offset, Err: ErrTruncated}
+		}
+		chunk := data[offset : offset+chunkSize(data[offset:])]
+		chunkData := chunk[8 : len(chunk)-4]
+
+		switch string(chunk[4:8]) {
+		case "IHDR":
+			h = parseImageHeader(chunkData)
+		case "PLTE":
+			plte = chunkData
+		case "tRNS":
+			trns = chunkData
+		case "IDAT":
+			idat = append(idat, chunkData...)
+		}
+		if string(chunk[4:8]) == "IEND" {
+			break
+		}
+		offset += len(chunk)
+	}
+
+	r, err := decodeRaster(h, idat)
+	if err != nil {
+		return h, nil, err
+	}
+	if orientation > 1 {
+		r = r.orient(orientation)
+		h.Width, h.Height = r.width, r.height
+	}
+	pix, err := r.rgba(plte, trns)
+	return h, pix, err
+}
+
+// verifies reports whether the output of opts is checked against the
+// input: always with Options.Verify, and for every mode touching IDAT or
+// the chunk order
+func verifies(opts Options) bool {
+	return opts.Verify || holdsChunks(opts) || rewritesIDAT(opts)
+}
+
+// verifyOutput checks that the stripped output of data has the same pixels,
+// once the orientation applied by Options.ApplyOrientation is applied to
+// the input. Inputs that cannot be decoded are not verified.
+func (s *stripper) verifyOutput(data, output []byte) error {
+	hIn, pixIn, err := decodePixels(data, s.result.Orientation)
+	if err != nil {
+		return nil
+	}
+
+	hOut, pixOut, err := decodePixels(output, 1)
+	if err != nil {
+		return fmt.Errorf("%w: output cannot be decoded: %v", ErrPixelMismatch, err)
+	}
+	if err := comparePixels(hIn, pixIn, hOut, pixOut); err != nil {
+		return err
+	}
+	s.result.Verified = true
+	return nil
+}
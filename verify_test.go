@@ -0,0 +1,128 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestVerifyPixelsEqual(t *testing.T) {
+	img := hiddenContentImage()
+	data := reductionPNG(t, img)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Failed to encode test image: %v", err)
+	}
+
+	changed := image.NewNRGBA(img.Bounds())
+	copy(changed.Pix, img.Pix)
+	changed.SetNRGBA(3, 5, color.NRGBA{1, 2, 3, 255})
+
+	hidden := image.NewNRGBA(img.Bounds())
+	copy(hidden.Pix, img.Pix)
+	hidden.SetNRGBA(40, 5, color.NRGBA{1, 2, 3, 0})
+
+	gray := filledNRGBA(func(x, y int) color.NRGBA {
+		v := uint8(noise(x, y, 16) * 17)
+		return color.NRGBA{v, v, v, 255}
+	})
+	reduced, _, err := StripWithOptions(reductionPNG(t, gray), Options{ReduceColor: true})
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		a, b []byte
+		err  error
+	}{
+		{"same encoding", data, data, nil},
+		{"other encoder", data, buf.Bytes(), nil},
+		{"other color type", reductionPNG(t, gray), reduced, nil},
+		{"hidden color", data, reductionPNG(t, hidden), nil},
+		{"changed pixel", data, reductionPNG(t, changed), ErrPixelMismatch},
+		{"other size", data, buildMetadataPNG(t), ErrPixelMismatch},
+		{"not a PNG", data, []byte("GIF89a"), ErrNotPNG},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := VerifyPixelsEqual(tt.a, tt.b); !errors.Is(err, tt.err) {
+				t.Errorf("Expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	img := hiddenContentImage()
+	data := reductionPNG(t, img)
+
+	tests := []struct {
+		name     string
+		opts     Options
+		verified bool
+	}{
+		{"metadata only", DefaultOptions(), false},
+		{"explicit", Options{Verify: true}, true},
+		{"recompress", Options{Recompress: true}, true},
+		{"clean transparent", Options{CleanTransparent: TransparentZero}, true},
+		{"merge IDAT", Options{MergeIDAT: true}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cleaned, result, err := StripWithOptions(data, tt.opts)
+			if err != nil {
+				t.Fatalf("Failed to process PNG: %v", err)
+			}
+			if result.Verified != tt.verified {
+				t.Errorf("Expected Verified %v, got %v", tt.verified, result.Verified)
+			}
+			if err := VerifyPixelsEqual(data, cleaned); err != nil {
+				t.Errorf("Output pixels differ: %v", err)
+			}
+		})
+	}
+
+	// Undecodable image data is passed through unverified
+	corrupt := buildPNG(makeChunk("IHDR", encodedChunks(t, img)["IHDR"]), makeChunk("IDAT", []byte{1, 2, 3}), makeChunk("IEND", nil))
+	_, result, err := StripWithOptions(corrupt, Options{Verify: true})
+	if err != nil {
+		t.Fatalf("Failed to process PNG: %v", err)
+	}
+	if result.Verified {
+		t.Error("Undecodable image reported as verified")
+	}
+}
+
+func TestVerifyInvalidHeader(t *testing.T) {
+	idat := makeChunk("IDAT", encodedChunks(t, image.NewGray(image.Rect(0, 0, 4, 4)))["IDAT"])
+	headers := map[string][]byte{
+		"bit depth 0": {0, 0, 0, 4, 0, 0, 0, 4, 0, 0, 0, 0, 0},
+		"oversized":   {0x7F, 0xFF, 0xFF, 0xFF, 0x7F, 0xFF, 0xFF, 0xFF, 8, 6, 0, 0, 0},
+		"too large":   {0, 1, 0x86, 0xA0, 0, 1, 0x86, 0xA0, 8, 6, 0, 0, 0}, // 100000x100000
+	}
+
+	for name, header := range headers {
+		t.Run(name, func(t *testing.T) {
+			data := buildPNG(makeChunk("IHDR", header), idat, makeChunk("IEND", nil))
+			if err := VerifyPixelsEqual(data, data); !errors.Is(err, ErrInvalidChunk) {
+				t.Errorf("Expected ErrInvalidChunk, got %v", err)
+			}
+
+			// Modes implying verification pass such images through unverified
+			cleaned, result, err := StripWithOptions(data, Options{MergeIDAT: true})
+			if err != nil {
+				t.Fatalf("Failed to process PNG: %v", err)
+			}
+			if result.Verified || !bytes.Equal(cleaned, data) {
+				t.Error("Expected the image to be passed through unverified")
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// Keyword of the iTXt chunk holding an XMP packet
+const xmpKeyword = "XML:com.adobe.xmp"
+
+// XML namespaces used to build XMP packets
+const (
+	nsRDF = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	nsXML = "http://www.w3.org/XML/1998/namespace"
+)
+
+// Namespaces of the common XMP schemas by their customary prefix, used to
+// resolve the prefixes in Options.XMPProperties
+var xmpNamespaces = map[string]string{
+	"dc":           "http://purl.org/dc/elements/1.1/",
+	"xmp":          "http://ns.adobe.com/xap/1.0/",
+	"xmpRights":    "http://ns.adobe.com/xap/1.0/rights/",
+	"xmpMM":        "http://ns.adobe.com/xap/1.0/mm/",
+	"photoshop":    "http://ns.adobe.com/photoshop/1.0/",
+	"tiff":         "http://ns.adobe.com/tiff/1.0/",
+	"exif":         "http://ns.adobe.com/exif/1.0/",
+	"Iptc4xmpCore": "http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/",
+	"plus":         "http://ns.useplus.org/ldf/xmp/1.0/",
+	"cc":           "http://creativecommons.org/ns#",
+}
+
+// processXMP writes an XMP iTXt chunk reduced to the properties listed in
+// Options.XMPProperties in place of a chunk the policy removes. The chunk is
+// removed entirely when it cannot be parsed or holds none of the properties.
+func (s *stripper) processXMP(chunk []byte) error {
+	info := s.currentChunk()
+	packet, err := parseXMPChunk(chunk[8 : len(chunk)-4])
+	if err == nil {
+		packet, err = filterXMP(packet, s.policy.opts.XMPProperties)
+	}
+	if err != nil {
+		s.warn("iTXt", info.Offset, "malformed XMP, chunk removed: %v", err)
+	}
+	if packet == nil {
+		trackRemovedChunk(s.result, "iTXt", len(chunk))
+		return nil
+	}
+
+	// Uncompressed, without language tag or translated keyword
+	data := append([]byte(xmpKeyword+"\x00\x00\x00\x00\x00"), packet...)
+	filtered := buildChunk("iTXt", data)
+	info.Kept = true
+	info.Reason = ReasonXMP
+	trackRemovedChunk(s.result, "iTXt", len(chunk)-len(filtered))
+	return s.write(filtered)
+}
+
+// parseXMPChunk returns the XMP packet held by iTXt data
+func parseXMPChunk(data []byte) ([]byte, error) {
+	// Keyword, compression flag and method, language tag, translated keyword
+	rest := data[len(xmpKeyword)+1:]
+	if len(rest) < 2 {
+		return nil, fmt.Errorf("%w: iTXt too short", ErrInvalidChunk)
+	}
+	compressed := rest[0] == 1
+	fields := bytes.SplitN(rest[2:], []byte{0}, 3)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("%w: iTXt fields missing", ErrInvalidChunk)
+	}
+	text := fields[2]
+
+	if !compressed {
+		return text, nil
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(text))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidChunk, err)
+	}
+	defer zr.Close()
+
+	packet, err := io.ReadAll(io.LimitReader(zr, maxProfileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidChunk, err)
+	}
+	if len(packet) > maxProfileSize {
+		return nil, fmt.Errorf("%w: XMP packet exceeds %d bytes", ErrInvalidChunk, maxProfileSize)
+	}
+	return packet, nil
+}
+
+// xmpFilter selects properties of an XMP packet and serializes them
+type xmpFilter struct {
+	props    [][2]string       // Prefix and local name, or "*" for a whole schema
+	prefixes map[string]string // Prefixes declared by the packet, by namespace
+	used     map[string]bool   // Namespaces referenced by the output
+}
+
+// filterXMP rebuilds an XMP packet with only the listed properties, given as
+// "prefix:name" or "prefix:*". The output has no packet wrapper and no
+// whitespace between elements. nil is returned when none of the properties
+// are present.
+func filterXMP(packet []byte, props []string) ([]byte, error) {
+	f := &xmpFilter{
+		prefixes: map[string]string{nsXML: "xml", nsRDF: "rdf"},
+		used:     map[string]bool{},
+	}
+	for _, prop := range props {
+		if prefix, local, ok := strings.Cut(prop, ":"); ok {
+			f.props = append(f.props, [2]string{prefix, local})
+		}
+	}
+
+	var attrs, elems bytes.Buffer
+	dec := xml.NewDecoder(bytes.NewReader(packet))
+	depth := -1 // Depth of the rdf:Description being read, -1 outside
+	for level := 0; ; {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			f.declare(t.Attr)
+			level++
+
+			if depth < 0 {
+				if t.Name.Space == nsRDF && t.Name.Local == "Description" {
+					depth = level
+					for _, a := range t.Attr {
+						if a.Name.Space != nsRDF && f.selected(a.Name) {
+							f.writeAttr(&attrs, a)
+						}
+					}
+				}
+				continue
+			}
+
+			// A property of the description
+			if f.selected(t.Name) {
+				if err := f.copyElement(&elems, dec, t); err != nil {
+					return nil, err
+				}
+			} else if err := dec.Skip(); err != nil {
+				return nil, err
+			}
+			level--
+		case xml.EndElement:
+			if level == depth {
+				depth = -1
+			}
+			level--
+		}
+	}
+
+	if attrs.Len() == 0 && elems.Len() == 0 {
+		return nil, nil
+	}
+
+	var out bytes.Buffer
+	out.WriteString(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="` + nsRDF + `"><rdf:Description rdf:about=""`)
+	namespaces := make([]string, 0, len(f.used))
+	for ns := range f.used {
+		if ns != nsRDF && ns != nsXML {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	slices.Sort(namespaces)
+	for _, ns := range namespaces {
+		fmt.Fprintf(&out, ` xmlns:%s="`, f.prefix(ns))
+		xml.EscapeText(&out, []byte(ns))
+		out.WriteByte('"')
+	}
+	out.Write(attrs.Bytes())
+	out.WriteByte('>')
+	out.Write(elems.Bytes())
+	out.WriteString(`</rdf:Description></rdf:RDF></x:xmpmeta>`)
+	return out.Bytes(), nil
+}
+
+// declare records the namespace prefixes declared by attrs
+func (f *xmpFilter) declare(attrs []xml.Attr) {
+	for _, a := range attrs {
+		if a.Name.Space == "xmlns" {
+			if _, ok := f.prefixes[a.Value]; !ok {
+				f.prefixes[a.Value] = a.Name.Local
+			}
+		}
+	}
+}
+
+// selected reports whether a property is listed in the options
+func (f *xmpFilter) selected(name xml.Name) bool {
+	for _, p := range f.props {
+		if p[1] != "*" && p[1] != name.Local {
+			continue
+		}
+		if xmpNamespaces[p[0]] == name.Space || f.prefixes[name.Space] == p[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// prefix returns the prefix used for a namespace in the output
+func (f *xmpFilter) prefix(ns string) string {
+	if prefix, ok := f.prefixes[ns]; ok {
+		return prefix
+	}
+	for prefix, known := range xmpNamespaces {
+		if known == ns {
+			f.prefixes[ns] = prefix
+			return prefix
+		}
+	}
+	prefix := fmt.Sprintf("ns%d", len(f.prefixes))
+	f.prefixes[ns] = prefix
+	return prefix
+}
+
+// qualified returns the prefixed form of name
+func (f *xmpFilter) qualified(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	f.used[name.Space] = true
+	return f.prefix(name.Space) + ":" + name.Local
+}
+
+func (f *xmpFilter) writeAttr(w *bytes.Buffer, a xml.Attr) {
+	w.WriteString(" " + f.qualified(a.Name) + `="`)
+	xml.EscapeText(w, []byte(a.Value))
+	w.WriteByte('"')
+}
+
+// copyElement serializes start and everything up to its end element,
+// dropping namespace declarations and whitespace between elements
+func (f *xmpFilter) copyElement(w *bytes.Buffer, dec *xml.Decoder, start xml.StartElement) error {
+	for depth := 0; ; {
+		var tok xml.Token = start
+		if depth > 0 {
+			var err error
+			if tok, err = dec.Token(); err != nil {
+				return err
+			}
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			f.declare(t.Attr)
+			depth++
+			w.WriteString("<" + f.qualified(t.Name))
+			for _, a := range t.Attr {
+				if a.Name.Space != "xmlns" && a.Name.Local != "xmlns" {
+					f.writeAttr(w, a)
+				}
+			}
+			w.WriteByte('>')
+		case xml.EndElement:
+			depth--
+			w.WriteString("</" + f.qualified(t.Name) + ">")
+			if depth == 0 {
+				return nil
+			}
+		case xml.CharData:
+			if len(bytes.TrimSpace(t)) > 0 {
+				xml.EscapeText(w, t)
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package pngmetawebstrip
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// adobeXMP is a packet in the style written by Adobe applications
+const adobeXMP = `<?xpacket begin="` + "\uFEFF" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/" x:xmptk="Adobe XMP Core 9.1-c001">
+   <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+      <rdf:Description rdf:about=""
+            xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+            xmlns:dc="http://purl.org/dc/elements/1.1/"
+            xmlns:xmpMM="http://ns.adobe.com/xap/1.0/mm/"
+            xmlns:stEvt="http://ns.adobe.com/xap/1.0/sType/ResourceEvent#"
+            xmlns:rights="http://ns.adobe.com/xap/1.0/rights/"
+            xmp:CreatorTool="Adobe Photoshop 25.0"
+            xmpMM:InstanceID="xmp.iid:0b5e7f2c-1d3a-4c4e-9f0a-2b6c8d1e3f4a"
+            rights:Marked="True">
+         <dc:format>image/png</dc:format>
+         <dc:rights>
+            <rdf:Alt>
+               <rdf:li xml:lang="x-default">(c) 2024 Example &amp; Co.</rdf:li>
+            </rdf:Alt>
+         </dc:rights>
+         <rights:WebStatement>https://example.com/license</rights:WebStatement>
+         <xmpMM:History>
+            <rdf:Seq>
+               <rdf:li rdf:parseType="Resource">
+                  <stEvt:action>created</stEvt:action>
+                  <stEvt:softwareAgent>Adobe Photoshop 25.0</stEvt:softwareAgent>
+               </rdf:li>
+            </rdf:Seq>
+         </xmpMM:History>
+      </rdf:Description>
+   </rdf:RDF>
+</x:xmpmeta>
+` + "                                                                                                    " + `
+<?xpacket end="w"?>`
+
+func xmpChunk(t *testing.T, packet string, compressed bool) []byte {
+	t.Helper()
+
+	data := []byte(xmpKeyword + "\x00\x00\x00\x00\x00")
+	if !compressed {
+		return makeChunk("iTXt", append(data, packet...))
+	}
+
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write([]byte(packet))
+	zw.Close()
+	data[len(xmpKeyword)+1] = 1
+	return makeChunk("iTXt", append(data, buf.Bytes()...))
+}
+
+func TestFilterXMP(t *testing.T) {
+	packet, err := filterXMP([]byte(adobeXMP), []string{"dc:rights", "xmpRights:*"})
+	if err != nil {
+		t.Fatalf("Failed to filter XMP: %v", err)
+	}
+	out := string(packet)
+
+	// The result is well-formed XML
+	dec := xml.NewDecoder(strings.NewReader(out))
+	for {
+		if _, err := dec.Token(); err != nil {
+			if !errors.Is(err, io.EOF) {
+				t.Fatalf("Filtered XMP is not well-formed: %v\n%s", err, out)
+			}
+			break
+		}
+	}
+
+	for _, expected := range []string{
+		`rights:Marked="True"`,
+		`<rdf:li xml:lang="x-default">(c) 2024 Example &amp; Co.</rdf:li>`,
+		`<rights:WebStatement>https://example.com/license</rights:WebStatement>`,
+		`xmlns:dc="http://purl.org/dc/elements/1.1/"`,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected %s in\n%s", expected, out)
+		}
+	}
+	for _, unexpected := range []string{"History", "InstanceID", "CreatorTool", "dc:format", "xpacket", "\n", "  "} {
+		if strings.Contains(out, unexpected) {
+			t.Errorf("Unexpected %q in\n%s", unexpected, out)
+		}
+	}
+
+	if packet, err := filterXMP([]byte(adobeXMP), []string{"photoshop:*"}); packet != nil || err != nil {
+		t.Errorf("Expected nil without matching properties, got %q, %v", packet, err)
+	}
+	if _, err := filterXMP([]byte("<x:xmpmeta><rdf:RDF>"), []string{"dc:*"}); err == nil {
+		t.Error("Expected an error for malformed XML")
+	}
+}
+
+func TestXMPPropertiesOption(t *testing.T) {
+	opts := DefaultOptions()
+	opts.XMPProperties = []string{"dc:rights", "xmpRights:*"}
+
+	tests := []struct {
+		name     string
+		chunk    []byte
+		kept     bool
+		warnings int
+	}{
+		{"Uncompressed", xmpChunk(t, adobeXMP, false), true, 0},
+		{"Compressed", xmpChunk(t, adobeXMP, true), true, 0},
+		{"No matching properties", xmpChunk(t, `<x:xmpmeta xmlns:x="adobe:ns:meta/"/>`, false), false, 0},
+		{"Malformed", xmpChunk(t, "<x:xmpmeta><unclosed>", false), false, 1},
+		{"Other iTXt", makeChunk("iTXt", []byte("Comment\x00\x00\x00\x00\x00hello")), false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := buildICCPNG(t, tt.chunk)
+			cleaned, result, err := StripWithOptions(data, opts)
+			if err != nil {
+				t.Fatalf("Failed to process PNG: %v", err)
+			}
+
+			if hasChunk(cleaned, "iTXt") != tt.kept {
+				t.Errorf("Expected iTXt kept=%v, got %v", tt.kept, chunkTypes(cleaned))
+			}
+			if len(result.Warnings) != tt.warnings {
+				t.Errorf("Expected %d warnings, got %v", tt.warnings, result.Warnings)
+			}
+			if result.Total != len(data)-len(cleaned) || result.Removed.TextChunks != result.Total {
+				t.Errorf("Unexpected savings: text=%d total=%d", result.Removed.TextChunks, result.Total)
+			}
+			if tt.kept && (result.Chunks[1].Reason != ReasonXMP || !bytes.Contains(cleaned, []byte("(c) 2024"))) {
+				t.Errorf("Expected the rights to be kept, got entry %+v", result.Chunks[1])
+			}
+		})
+	}
+}